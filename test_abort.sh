@@ -0,0 +1,91 @@
+#!/usr/bin/env bash
+# Abort tests: runs stopped halfway through the export, by an error or by Ctrl+C (a wrapper raises the exception
+# when the tool buckets the Nth card), with and without compression, and complete runs that roll over and merge
+# small countries. Every output file must be well-formed XML with exactly one closing </root> tag at its end.
+# ./test_abort.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+# 40 cards over 4 countries (one with only 2 cards), long enough for a few files per country with -M 1000
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i in range(1, 41):
+    country = "LU" if i in (7, 29) else ["BE", "NL", "DE"][i % 3]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i} {"x" * 200}"/></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+# runs the tool with STOP_WITH (an exception class) raised when the STOP_AT-th card is bucketed
+cat > "$work/inject.py" <<'EOF'
+import builtins, os, sys
+sys.argv = sys.argv[1:]
+sys.path.insert(0, os.path.dirname(sys.argv[0]))
+import peppol_sync
+stop_at, stop_with = int(os.environ.get("STOP_AT", "0")), os.environ.get("STOP_WITH")
+seen = 0
+bucket_for = peppol_sync.PeppolSync.bucket_for
+def stopping_bucket_for(self, element, country):
+    global seen
+    seen += 1
+    if stop_with and seen == stop_at:
+        raise getattr(builtins, stop_with)("injected")
+    return bucket_for(self, element, country)
+peppol_sync.PeppolSync.bucket_for = stopping_bucket_for
+sys.exit(peppol_sync.main())
+EOF
+
+failed=0
+check() {  # name, expected exit code, options...: a run in its own directory, then every output file is checked
+    local name=$1 expected=$2
+    shift 2
+    local dir="$work/$name"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$work/inject.py" "$root/peppol_sync.py" sync -K -M 1000 "$@" > /dev/null 2> "$dir/stderr.txt")
+    local status=$?
+    if [ $status != "$expected" ]; then
+        echo "FAILED   $name: exit code $status instead of $expected"
+        cat "$dir/stderr.txt"
+        failed=1
+    elif ! python3 - "$dir/extracts" <<'EOF'
+import bz2, gzip, lzma, pathlib, sys, xml.etree.ElementTree as ET
+openers = {".gz": gzip.open, ".bz2": bz2.open, ".xz": lzma.open}
+problems, count = [], 0
+for path in sorted(pathlib.Path(sys.argv[1]).glob("*/business-cards.*")):
+    count += 1
+    with openers.get(path.suffix, open)(path, "rb") as f:
+        data = f.read()
+    if data.count(b"</root>") != 1 or not data.endswith(b"</root>\n"):
+        problems.append(f"{path}: {data.count(b'</root>')} closing tags, ends with {data[-20:]!r}")
+    try:
+        ET.fromstring(data)
+    except ET.ParseError as e:
+        problems.append(f"{path} is not well-formed: {e}")
+if count < 3:
+    problems.append(f"only {count} output files")
+if problems:
+    print("\n".join(problems))
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+check "complete run" 0
+check "small countries merged" 0 --group-small-below 3
+STOP_AT=25 STOP_WITH=RuntimeError check "error halfway" 1
+STOP_AT=25 STOP_WITH=KeyboardInterrupt check "Ctrl+C halfway" 130
+STOP_AT=25 STOP_WITH=RuntimeError check "error halfway, gzip" 1 --compress gzip -M 300
+STOP_AT=25 STOP_WITH=KeyboardInterrupt check "Ctrl+C halfway, xz" 130 --compress xz -M 300
+check "limit" 0 --limit 20
+exit $failed
@@ -0,0 +1,88 @@
+#!/usr/bin/env bash
+# Auto-tune benchmark: syncs exports of three sizes (about 200 KB, 40 MB and 120 MB) once with the default settings and
+# once with --auto-tune, and prints the chosen settings and both timings. The tuned run must write the same files,
+# record its settings in run.json with source "auto", pick larger settings for each larger input (some grow, none
+# shrink) and one enricher worker below 10 MB, and be no slower than the defaults (with some slack for a noisy machine).
+# Explicit flags must win over the tuned values.
+# ./test_auto_tune.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+failed=0
+run() {  # scale, name, options...: a sync of the export of that scale in its own directory, timed
+    local dir="$work/$1/$2" scale=$1
+    shift 2
+    mkdir -p "$dir/tmp" "$dir/docs"
+    ln -s "$work/$scale.xml" "$dir/tmp/directory-export-business-cards.xml"
+    date +%s.%N > "$dir/times"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -S -M 100000000 "$@" > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+    date +%s.%N >> "$dir/times"
+}
+
+for scale in 60 13000 40000; do
+    python3 - "$work/$scale.xml" "$scale" <<'EOF'
+import sys
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n')
+    for i in range(int(sys.argv[2])):
+        country = ["BE", "NL", "DE", "FR", "IT", "ES"][i % 6]
+        f.write(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:08d}"/>'
+                f'<entity countrycode="{country}"><name name="Company {i} {"x" * 3000}"/></entity></businesscard>\n')
+    f.write("</root>\n")
+EOF
+    run $scale default
+    run $scale tuned --auto-tune
+done
+run 60 flags --auto-tune --write-buffer-kb 16 --enrich-workers 3
+
+if ! python3 - "$work" <<'EOF'
+import filecmp, json, pathlib, sys
+work = pathlib.Path(sys.argv[1])
+problems, previous = [], None
+print(f"{'export':>10} {'download':>9} {'read':>9} {'write':>9} {'workers':>8} {'default':>8} {'tuned':>8}")
+for scale in ("60", "13000", "40000"):
+    default, tuned = work / scale / "default", work / scale / "tuned"
+    for dir in (default, tuned):
+        if (dir / "status").read_text().strip() != "0":
+            problems.append(f"{dir.relative_to(work)} failed: {(dir / 'stderr.txt').read_text()}")
+    if problems:
+        break
+    tuning = json.loads((tuned / "extracts/run.json").read_text())["tuning"]
+    settings = {name: setting["value"] for name, setting in tuning["settings"].items()}
+    size = tuning["inputs"]["input_bytes"]
+    seconds = [(lambda start, end: end - start)(*map(float, (dir / "times").read_text().split()))
+               for dir in (default, tuned)]
+    print(f"{size / 1024 / 1024:>8.1f}MB {settings['download_chunk']:>9} {settings['read_chunk']:>9} "
+          f"{settings['write_buffer']:>9} {settings['enrich_workers']:>8} {seconds[0]:>7.2f}s {seconds[1]:>7.2f}s")
+    names = sorted(p.relative_to(default).as_posix() for p in default.glob("extracts/*/*.xml"))
+    if not names or names != sorted(p.relative_to(tuned).as_posix() for p in tuned.glob("extracts/*/*.xml")) \
+            or not all(filecmp.cmp(default / n, tuned / n, shallow=False) for n in names):
+        problems.append(f"{scale} cards: the tuned run wrote other files")
+    if any(setting["source"] != "auto" for setting in tuning["settings"].values()):
+        problems.append(f"{scale} cards: not every setting was tuned: {tuning['settings']}")
+    if size < 10 * 1024 * 1024 and settings["enrich_workers"] != 1:
+        problems.append(f"{scale} cards: {settings['enrich_workers']} enricher workers for {size} bytes")
+    if previous and (any(settings[n] < previous[n] for n in settings) or settings == previous):
+        problems.append(f"{scale} cards: no larger settings than for a smaller export: {previous} then {settings}")
+    if seconds[1] > seconds[0] * 1.5 + 1:
+        problems.append(f"{scale} cards: tuned run took {seconds[1]:.2f}s, the defaults {seconds[0]:.2f}s")
+    previous = settings
+
+flags = json.loads((work / "60/flags/extracts/run.json").read_text())["tuning"]["settings"]
+if flags["write_buffer"] != {"value": 16384, "source": "flag"} or flags["enrich_workers"] != {"value": 3, "source": "flag"} \
+        or flags["read_chunk"]["source"] != "auto":
+    problems.append(f"explicit flags: {flags}")
+if problems:
+    print("\n".join(problems))
+sys.exit(1 if problems else 0)
+EOF
+then
+    echo "FAILED   auto-tune"
+    failed=1
+else
+    echo "ok       auto-tune"
+fi
+exit $failed
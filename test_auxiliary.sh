@@ -0,0 +1,94 @@
+#!/usr/bin/env bash
+# Auxiliary artifact tests: runs where the report, stats.json, run.json, the diff, the offsets index, the capability
+# matrix or the log can't be written (a directory or file is in the way, which also works as root). With
+# --auxiliary-error-policy warn the run succeeds with the same country files as without obstacles, and run.json
+# (in tmp/ when extracts/run.json is blocked) lists what wasn't written; with the default policy fail it fails.
+# ./test_auxiliary.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i in range(1, 13):
+    country = ["BE", "NL", "DE"][i % 3]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i} {"x" * 200}"/></entity>'
+                 f'<doctypeid scheme="busdox-docid-qns" value="Invoice"/></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+failed=0
+run() {  # name, obstacles (paths made into directories, or files when ending in /), options...
+    local dir="$work/$1" obstacle
+    mkdir -p "$dir/tmp" "$dir/docs" "$dir/extracts"
+    cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    for obstacle in $2; do
+        case $obstacle in
+            */) echo "in the way" > "$dir/${obstacle%/}" ;;
+            *) mkdir -p "$dir/$obstacle/blocked" ;;
+        esac
+    done
+    shift 2
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -M 1000 -D --offsets-index --emit-capability-matrix "$@" \
+        > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+}
+check() {  # name, checks...: python expressions over the outcome of run name
+    local name=$1
+    shift
+    if ! python3 - "$work" "$name" "$@" <<'EOF'
+import filecmp, json, pathlib, sys
+work = pathlib.Path(sys.argv[1])
+dir = work / sys.argv[2]
+status = int((dir / "status").read_text())
+output = (dir / "stdout.txt").read_text(encoding="utf-8") + (dir / "stderr.txt").read_text(encoding="utf-8")
+
+def run_json(path="extracts/run.json"):
+    return json.loads((dir / path).read_text()) if (dir / path).is_file() else {}
+
+def same_cards():
+    """the country files are those of the run without obstacles"""
+    names = sorted(p.relative_to(work / "reference").as_posix() for p in (work / "reference").glob("extracts/*/*.xml"))
+    return len(names) > 3 and names == sorted(p.relative_to(dir).as_posix() for p in dir.glob("extracts/*/*.xml")) \
+        and all(filecmp.cmp(work / "reference" / n, dir / n, shallow=False) for n in names)
+
+problems = [check for check in sys.argv[3:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print(f"exit code {status}\n{output}")
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+run reference ""
+check reference 'status == 0' 'run_json()["auxiliary_failures"] == {}'
+run "warn, no obstacles" "" --auxiliary-error-policy warn
+check "warn, no obstacles" 'status == 0' 'run_json()["auxiliary_failures"] == {}' '"Not written" not in output'
+
+blocked="docs/report.md extracts/stats.json extracts/run.json extracts/_diff/ extracts/offsets.idx extracts/matrix.csv.gz log/"
+run "warn, all blocked" "$blocked" --auxiliary-error-policy warn
+check "warn, all blocked" 'status == 0' 'same_cards()' \
+    'not (dir / "extracts/run.json").is_file() and run_json("tmp/run.json")["status"] == "success"' \
+    'sorted(run_json("tmp/run.json")["auxiliary_failures"]) == ["diff", "log", "matrix", "offsets index", "report", "run.json", "stats.json"]' \
+    '"Not written: diff, log, matrix, offsets index, report, run.json, stats.json" in output'
+
+run "warn, report blocked" "docs/report.md" --auxiliary-error-policy warn
+check "warn, report blocked" 'status == 0' 'same_cards()' \
+    'list(run_json()["auxiliary_failures"]) == ["report"]' '(dir / "extracts/stats.json").is_file()'
+
+for artifact in docs/report.md extracts/stats.json extracts/run.json extracts/_diff/ log/; do
+    run "fail, $artifact blocked" "$artifact"
+    check "fail, $artifact blocked" 'status != 0' 'run_json().get("status") in (None, "failure")'
+done
+exit $failed
@@ -0,0 +1,161 @@
+#!/usr/bin/env bash
+# Reproducibility bundle tests: sync --bundle writes runs/<run id>/bundle.tar.zst with the export, the resolved
+# configuration, run.json, stats.json, the manifest of the card files, the report and REPRODUCE.json; reproduce
+# --bundle re-runs it in another directory and finds the same card files. A bundle without the export downloads it
+# again from its URL (a local HTTP server here) and must refuse an export that changed since; a bundle whose
+# manifest doesn't match the re-run names the files that differ. Credentials of the run never go in a bundle.
+# ./test_bundle.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+server=""
+trap '[ -n "$server" ] && kill $server; rm -rf "$work"' EXIT
+if ! command -v zstd > /dev/null; then
+    echo "skipped  (no zstd command)"
+    exit 0
+fi
+
+export_with() {  # file, name suffix: 12 cards over 3 countries, long enough for a few files per country with -M 1000
+    python3 - "$1" "$2" <<'EOF'
+import sys
+cards = []
+for i in range(1, 13):
+    country = ["BE", "NL", "DE"][i % 3]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i}{sys.argv[2]} {"x" * 200}"/></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+}
+
+mkdir -p "$work/www"
+export_with "$work/www/export.xml" ""
+port=$(python3 -c 'import socket; s = socket.socket(); s.bind(("127.0.0.1", 0)); print(s.getsockname()[1])')
+python3 -m http.server "$port" --bind 127.0.0.1 --directory "$work/www" > /dev/null 2>&1 &
+server=$!
+url="http://127.0.0.1:$port/export.xml"
+for _ in $(seq 50); do
+    python3 -c "import urllib.request; urllib.request.urlopen('$url')" 2> /dev/null && break
+    sleep 0.1
+done
+
+failed=0
+run() {  # name, action, options...: the tool in its own directory
+    local dir="$work/$1" action=$2
+    shift 2
+    mkdir -p "$dir/docs"
+    (cd "$dir" && python3 "$root/peppol_sync.py" "$action" "$@" > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+}
+check() {  # name, checks...: python expressions over the outcome of run name
+    local name=$1
+    shift
+    if ! python3 - "$work" "$name" "$root" "$@" <<'EOF'
+import hashlib, io, json, pathlib, subprocess, sys, tarfile
+work = pathlib.Path(sys.argv[1])
+dir = work / sys.argv[2]
+version = (pathlib.Path(sys.argv[3]) / "VERSION.md").read_text().strip()
+status = int((dir / "status").read_text())
+output = (dir / "stdout.txt").read_text(encoding="utf-8") + (dir / "stderr.txt").read_text(encoding="utf-8")
+
+def bundle(path="runs/20260101T000000Z/bundle.tar.zst"):
+    """name: content of every member of a bundle"""
+    data = subprocess.run(["zstd", "-q", "-d", "-c", str(dir / path)], capture_output=True, check=True).stdout
+    with tarfile.open(fileobj=io.BytesIO(data)) as tar:
+        return {m.name: tar.extractfile(m).read() for m in tar.getmembers() if m.isfile()}
+
+def reproduce():
+    return json.loads(bundle()["REPRODUCE.json"])
+
+def card_files():
+    return {p.relative_to(dir / "extracts").as_posix(): hashlib.sha256(p.read_bytes()).hexdigest()
+            for p in sorted(dir.glob("extracts/*/business-cards.*"))}
+
+problems = [check for check in sys.argv[4:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print(f"exit code {status}\n{output}")
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+run original sync --url "$url" --deterministic -M 1000 --bundle
+check original 'status == 0' '"Bundle: runs/20260101T000000Z/bundle.tar.zst (with the export)" in output' \
+    'sorted(bundle()) == ["REPRODUCE.json", "config.json", "export/" + reproduce()["source"]["file"], "manifest.json",
+                         "report.md", "run.json", "stats.json"]' \
+    'bundle()["run.json"] == (dir / "extracts/run.json").read_bytes() and bundle()["report.md"] == (dir / "docs/report.md").read_bytes()' \
+    '{name: entry["sha256"] for name, entry in json.loads(bundle()["manifest.json"])["files"].items()} == card_files() and len(card_files()) > 3' \
+    'json.loads(bundle()["config.json"])["max_bytes"] == 1000 and json.loads(bundle()["config.json"])["export_url"].endswith("/export.xml")' \
+    'json.loads(bundle()["config.json"])["split_by"] == "country"' \
+    'reproduce()["tool"]["version"] == version' 'reproduce()["source"]["url"].endswith("/export.xml")' \
+    'reproduce()["source"]["sha256"] == hashlib.sha256((work / "www/export.xml").read_bytes()).hexdigest()'
+
+run reproduced reproduce --bundle "$work/original/runs/20260101T000000Z/bundle.tar.zst"
+check reproduced 'status == 0' '"all " in output and " card files are identical" in output' \
+    'not list(dir.glob("tmp/reproduce-*"))'
+
+run "without export" sync --url "$url" --deterministic -M 1000 --bundle --bundle-without-export \
+    --auth-bearer s3cret-token --header "X-Api-Key: s3cret-key" --header "Accept: text/xml"
+check "without export" 'status == 0' '"export by SHA-256 and URL" in output' \
+    'not any(name.startswith("export/") for name in bundle())' \
+    'reproduce()["source"]["included"] is False' \
+    'not any(b"s3cret" in content for content in bundle().values())' \
+    'json.loads(bundle()["config.json"])["headers"] == {"X-Api-Key": "(redacted)", "Accept": "text/xml"}' \
+    '"--auth-bearer" in reproduce()["command"] and "(redacted)" in reproduce()["command"]'
+
+run "reproduced from the URL" reproduce --bundle "$work/without export/runs/20260101T000000Z/bundle.tar.zst"
+check "reproduced from the URL" 'status == 0' '" card files are identical" in output' \
+    '"which the bundle leaves out" in output'
+
+run "from --input" sync --input "$work/www/export.xml" --deterministic -M 1000 --bundle
+run "reproduced from --input" reproduce --bundle "$work/from --input/runs/20260101T000000Z/bundle.tar.zst"
+check "reproduced from --input" 'status == 0' '" card files are identical" in output'
+
+run "file name template" sync --input "$work/www/export.xml" --deterministic -M 1000 --bundle \
+    --filename-template "peppol_{country}_{seq}.{ext}"
+check "file name template" 'status == 0' \
+    'sorted(json.loads(bundle()["manifest.json"])["files"]) == sorted(p.relative_to(dir / "extracts").as_posix()
+                                                                    for p in dir.glob("extracts/*/peppol_*.xml"))' \
+    'len(json.loads(bundle()["manifest.json"])["files"]) > 3'
+run "reproduced with the template" reproduce --bundle "$work/file name template/runs/20260101T000000Z/bundle.tar.zst"
+check "reproduced with the template" 'status == 0' '" card files are identical" in output'
+
+run "--input without export" sync --input "$work/www/export.xml" --deterministic -M 1000 --bundle --bundle-without-export
+run "no export, no URL" reproduce --bundle "$work/--input without export/runs/20260101T000000Z/bundle.tar.zst"
+check "no export, no URL" 'status == 1' '"has neither the export nor its URL" in output'
+
+# the manifest says otherwise for one file
+mkdir -p "$work/tampered/runs/20260101T000000Z"
+python3 - "$work/original/runs/20260101T000000Z/bundle.tar.zst" "$work/tampered/runs/20260101T000000Z/bundle.tar.zst" <<'EOF'
+import io, json, subprocess, sys, tarfile
+data = subprocess.run(["zstd", "-q", "-d", "-c", sys.argv[1]], capture_output=True, check=True).stdout
+out = io.BytesIO()
+with tarfile.open(fileobj=io.BytesIO(data)) as tar, tarfile.open(fileobj=out, mode="w") as copy:
+    for member in tar.getmembers():
+        content = tar.extractfile(member).read()
+        if member.name == "manifest.json":
+            manifest = json.loads(content)
+            manifest["files"]["NL/business-cards.000001.xml"]["sha256"] = "0" * 64
+            manifest["files"]["XX/business-cards.000001.xml"] = {"sha256": "0" * 64, "size": 1}
+            content = json.dumps(manifest).encode()
+            member.size = len(content)
+        copy.addfile(member, io.BytesIO(content))
+subprocess.run(["zstd", "-q", "-f", "-o", sys.argv[2]], input=out.getvalue(), check=True)
+EOF
+run "manifest differs" reproduce --bundle "$work/tampered/runs/20260101T000000Z/bundle.tar.zst"
+check "manifest differs" 'status == 1' '"did not reproduce: 1 card files differ, 1 missing, 0 extra" in output' \
+    '"differs  NL/business-cards.000001.xml" in output and "missing  XX/business-cards.000001.xml" in output' \
+    'list(dir.glob("tmp/reproduce-*/extracts/NL/business-cards.000001.xml"))'
+
+# the export at the URL changed since the bundle was made
+export_with "$work/www/export.xml" " (renamed)"
+run "export changed" reproduce --bundle "$work/without export/runs/20260101T000000Z/bundle.tar.zst"
+check "export changed" 'status == 1' '"Checksum mismatch" in output and "reproduced run failed" in output'
+exit $failed
@@ -0,0 +1,115 @@
+#!/usr/bin/env bash
+# Console output tests: stdout and stderr of runs at each console level. By default progress lines (every 100,000
+# cards), announcements and the summary go to stdout; --no-progress drops the progress lines only; --silent prints
+# nothing at all on success. Errors always go to stderr, on a line of their own, also with --silent, and the log
+# file is written at every level. The summary is colored on a terminal only, and not with --no-color or NO_COLOR.
+# ./test_console.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+# 100,001 small cards: enough for one progress line
+python3 - "$work/export.xml" <<'EOF'
+import sys
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n')
+    for i in range(100001):
+        f.write(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:06d}"/>'
+                f'<entity countrycode="{"BE" if i % 2 else "NL"}"><name name="C{i}"/></entity></businesscard>\n')
+    f.write("</root>\n")
+EOF
+head -5 "$work/export.xml" > "$work/small.xml"
+echo "</root>" >> "$work/small.xml"
+
+failed=0
+check() {  # name, export (or "broken"), expected exit code, options, checks...: python expressions over stdout/stderr
+    local name=$1 export=$2 expected=$3 options=$4
+    shift 4
+    local dir="$work/$name"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    if [ "$export" = broken ]; then
+        echo "not xml" > "$dir/tmp/directory-export-business-cards.xml"
+    else
+        cp "$export" "$dir/tmp/directory-export-business-cards.xml"
+    fi
+    # shellcheck disable=SC2086
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K $options > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    local status=$?
+    if [ $status != "$expected" ]; then
+        echo "FAILED   $name: exit code $status instead of $expected"
+        cat "$dir/stderr.txt"
+        failed=1
+    elif ! python3 - "$dir" "$@" <<'EOF'
+import pathlib, sys
+dir = pathlib.Path(sys.argv[1])
+out = open(dir / "stdout.txt", encoding="utf-8", newline="").read()  # keeps the \r of progress lines
+err = (dir / "stderr.txt").read_text(encoding="utf-8")
+log = (dir / "log/peppol_sync.log").read_text(encoding="utf-8")
+progress = "\r... 100,000 business cards in" in out
+problems = [check for check in sys.argv[2:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print(f"stdout: {out!r}\nstderr: {err!r}")
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+check "default" "$work/export.xml" 0 "" \
+    'progress' '"\x1b[" not in out' '"⏳  Processing" in out and "✅  Sync complete!" in out and "📊 Summary" in out' \
+    'err == ""' '"Processed 100,001 business cards" in log'
+check "no progress" "$work/export.xml" 0 "--no-progress" \
+    'not progress and "\r" not in out and "... " not in out' \
+    '"⏳  Processing" in out and "✅  Sync complete!" in out and "📊 Summary" in out' \
+    'err == ""'
+check "silent" "$work/export.xml" 0 "--silent" \
+    'out == "" and err == ""' '"Processed 100,001 business cards" in log'
+check "silent, short option" "$work/small.xml" 0 "-S" \
+    'out == "" and err == ""'
+check "error" broken 1 "" \
+    'err.startswith("❌ ") and err.endswith("\n") and err.count("\n") == 1' \
+    '"does not look like XML" in err and "❌" not in out and "⏳  Processing" in out'
+check "silent error" broken 1 "--silent" \
+    'out == ""' 'err.startswith("❌ ") and "does not look like XML" in err' \
+    '"does not look like XML" in log'
+
+# on a terminal: the same run under a pseudo-terminal, with and without colors
+tty_check() {  # name, NO_COLOR value, options, expect colors (yes/no)
+    local name=$1 no_color=$2 options=$3 colors=$4
+    local dir="$work/$name"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/small.xml" "$dir/tmp/directory-export-business-cards.xml"
+    # shellcheck disable=SC2086
+    if ! (cd "$dir" && NO_COLOR=$no_color python3 - "$root/peppol_sync.py" sync -K $options > "$dir/tty.txt" <<'EOF'
+import os, pty, sys
+if not os.environ["NO_COLOR"]:
+    del os.environ["NO_COLOR"]
+status = pty.spawn([sys.executable] + sys.argv[1:])
+sys.exit(os.waitstatus_to_exitcode(status))
+EOF
+    ); then
+        echo "FAILED   $name: the run failed"
+        failed=1
+    elif [ "$colors" = yes ] && ! grep -qF "$(printf '\033[1m📊 Summary\033[0m')" "$dir/tty.txt"; then
+        echo "FAILED   $name: no colored summary on a terminal"
+        failed=1
+    elif [ "$colors" = no ] && grep -qF "$(printf '\033[')" "$dir/tty.txt"; then
+        echo "FAILED   $name: colors despite $options NO_COLOR=$no_color"
+        failed=1
+    elif ! grep -q "Total business cards  *3" "$dir/tty.txt"; then
+        echo "FAILED   $name: no summary"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+tty_check "terminal" "" "" yes
+tty_check "terminal, --no-color" "" "--no-color" no
+tty_check "terminal, NO_COLOR" "1" "" no
+exit $failed
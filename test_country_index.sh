@@ -0,0 +1,66 @@
+#!/usr/bin/env bash
+# --country-index tests: syncs every testdata/*.xml export with --country-index. The card files must be those of
+# the golden run, and every card must be readable on its own at the offset and length of its row in index.csv:
+# lxml parses exactly one card there (in the root start tag of its file, for the namespace declarations), with the
+# participant of the row. Every bucket has a row per card.
+# ./test_country_index.sh [name ...]
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+names=("$@")
+if [ ${#names[@]} -eq 0 ]; then
+    for fixture in "$root"/testdata/*.xml; do
+        names+=("$(basename "$fixture" .xml)")
+    done
+fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+failed=0
+for name in "${names[@]}"; do
+    dir="$work/$name"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$root/testdata/$name.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -S --deterministic --no-progress -M 20000 --country-index \
+        > /dev/null 2> "$dir/stderr.txt")
+    if ! diff -r -q -x '*.json' -x index.csv "$root/testdata/golden/$name/extracts" "$dir/extracts"; then
+        echo "FAILED   $name: --country-index wrote other card files than the golden run"
+        cat "$dir/stderr.txt"
+        failed=1
+    elif ! python3 - "$dir/extracts" <<'EOF'
+import csv, json, pathlib, re, sys
+from lxml import etree
+extracts = pathlib.Path(sys.argv[1])
+cards = json.loads((extracts / "stats.json").read_text(encoding="utf-8"))["cards_by_bucket"]
+for bucket, count in sorted(cards.items()):
+    with open(extracts / bucket / "index.csv", newline="", encoding="utf-8") as f:
+        rows = list(csv.DictReader(f))
+    if len(rows) != count:
+        sys.exit(f"{bucket}: {len(rows)} cards in index.csv, {count} in stats.json")
+    for row in rows:
+        with open(extracts / bucket / row["file"], "rb") as f:
+            start = re.search(rb"<[^?!][^>]*>", f.read(int(row["offset"]))).group()
+            f.seek(int(row["offset"]))
+            card = f.read(int(row["length"]))
+        name = re.match(rb"<([^\s>]+)", start).group(1)
+        try:
+            parsed = [element for element in etree.fromstring(start + card + b"</" + name + b">")
+                      if isinstance(element.tag, str)]
+        except etree.XMLSyntaxError as e:
+            sys.exit(f"{row['file']} in {bucket} at {row['offset']}: not one card: {e}")
+        participant = next((element for element in parsed[0].iter() if isinstance(element.tag, str)
+                            and element.tag.rsplit("}", 1)[-1] == "participant"), None) if len(parsed) == 1 else None
+        value = participant.get("value") if participant is not None else None
+        found = (f"{participant.get('scheme')}::{value}" if participant.get("scheme") else value) if value else ""
+        if len(parsed) != 1 or found != row["participant"]:
+            sys.exit(f"{row['file']} in {bucket} at {row['offset']}: {len(parsed)} cards, {found or 'no participant'} "
+                     f"instead of {row['participant']}")
+EOF
+    then
+        echo "FAILED   $name: a card can't be read back at its --country-index offset"
+        cat "$dir/stderr.txt"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+done
+exit $failed
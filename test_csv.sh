@@ -0,0 +1,74 @@
+#!/usr/bin/env bash
+# --format csv tests: syncs every testdata/*.xml export to CSV files of three cards each. The run must end with the
+# exit code of the golden run, every file must start with the header, and a CSV parser must read back the rows of
+# the cards of the export, quotes, separators and line breaks in the fields included, in the order of the golden
+# XML files of their bucket.
+# ./test_csv.sh [name ...]
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+names=("$@")
+if [ ${#names[@]} -eq 0 ]; then
+    for fixture in "$root"/testdata/*.xml; do
+        names+=("$(basename "$fixture" .xml)")
+    done
+fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+failed=0
+for name in "${names[@]}"; do
+    dir="$work/$name"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$root/testdata/$name.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -S --deterministic --no-progress --format csv --max-cards 3 \
+        > /dev/null 2> "$dir/stderr.txt")
+    echo "$?" > "$dir/exit-code"
+    if ! cmp -s "$dir/exit-code" "$root/testdata/golden/$name/exit-code"; then
+        echo "FAILED   $name: exit code $(cat "$dir/exit-code"), the golden run has $(cat "$root/testdata/golden/$name/exit-code")"
+        cat "$dir/stderr.txt"
+        failed=1
+    elif ! mkdir -p "$dir/check" || ! (cd "$dir/check" \
+            && python3 - "$root" "$root/testdata/$name.xml" "$root/testdata/golden/$name/extracts" "$dir/extracts" <<'EOF'
+import csv, pathlib, re, sys
+sys.path.insert(0, sys.argv[1])
+from peppol_sync import CSV_COLUMNS, PeppolSync, card_rows
+export_file, xml_extracts, csv_extracts = map(pathlib.Path, sys.argv[2:])
+# the rows of each card of the export, by participant (unique in the fixtures)
+sync = PeppolSync()
+export = {}
+for card in sync.split_cards(export_file):
+    try:
+        element = sync.parse_card(card)
+    except Exception:  # the malformed card, dead-lettered
+        continue
+    value = re.search(rb'<(?:[\w.-]+:)?participant\b[^>]*\bvalue="([^"]*)"', card)
+    export[value.group(1).decode() if value else None] = card_rows(element)
+buckets = sorted({path.parent.name for path in xml_extracts.glob("*/business-cards.*.xml")})
+if buckets != sorted({path.parent.name for path in csv_extracts.glob("*/business-cards.*.csv")}):
+    sys.exit(f"CSV files in other buckets than the XML files: {buckets}")
+for bucket in buckets:
+    participants = [value for path in sorted((xml_extracts / bucket).glob("business-cards.*.xml"))
+                    for value in re.findall(r'<(?:[\w.-]+:)?participant\b[^>]*\bvalue="([^"]*)"',
+                                            path.read_text(encoding="utf-8"))]
+    expected, rows = [row for value in participants for row in export[value]], []
+    files = sorted((csv_extracts / bucket).glob("business-cards.*.csv"))
+    if len(files) != (len(participants) + 2) // 3:
+        sys.exit(f"{bucket}: {len(files)} CSV files for {len(participants)} cards")
+    for path in files:
+        with open(path, newline="", encoding="utf-8") as f:
+            read = list(csv.reader(f))
+        if read[:1] != [CSV_COLUMNS]:
+            sys.exit(f"{bucket}/{path.name} doesn't start with the header: {read[:1]}")
+        rows += read[1:]
+    if rows != expected:
+        sys.exit(f"{bucket}: the CSV files read back {rows}, the cards are {expected}")
+EOF
+    ); then
+        echo "FAILED   $name: --format csv doesn't read back the rows of the cards"
+        cat "$dir/stderr.txt"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+done
+exit $failed
@@ -0,0 +1,68 @@
+#!/usr/bin/env bash
+# Deterministic mode tests: the same export synced twice with --deterministic, a second apart, must give the same
+# files with the same SHA-256: the extracts of every bucket and output option, the offset index, run.json, the
+# diff snapshot and the report. The log is the only file allowed to differ.
+# ./test_deterministic.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+# 30 cards over 5 countries with names long enough for a few files per country
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i in range(1, 31):
+    country = ["BE", "NL", "DE", "FR", "LU"][i * 7 % 5]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i * 7919 % 10000:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i} {"x" * 150}"/>'
+                 f'<regdate>20{i % 20 + 2:02d}-01-01</regdate></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+failed=0
+run() {  # name, options...: sync the export in the same directory, keeping the files of the run in $work/name
+    local name=$1
+    shift
+    rm -rf "$work/run"
+    mkdir -p "$work/run/tmp" "$work/run/docs"
+    cp "$work/export.xml" "$work/run/tmp/directory-export-business-cards.xml"
+    if ! (cd "$work/run" && python3 "$root/peppol_sync.py" sync -K -M 1000 --deterministic "$@" > /dev/null 2> "$work/stderr.txt"); then
+        echo "FAILED   $name: the run failed:"
+        cat "$work/stderr.txt"
+        failed=1
+        return 1
+    fi
+    rm -rf "$work/run/log" "$work/run/tmp"
+    mv "$work/run" "$work/$name"
+}
+check() {  # name, options...: two runs with these options, a second apart
+    local name=$1
+    shift
+    run "$name-1" "$@" || return
+    sleep 1
+    run "$name-2" "$@" || return
+    (cd "$work/$name-1" && find . -type f | sort | xargs sha256sum) > "$work/$name-1.sha256"
+    if ! (cd "$work/$name-2" && sha256sum --quiet -c "$work/$name-1.sha256" > "$work/$name.diff" 2>&1) \
+       || [ "$(cd "$work/$name-2" && find . -type f | wc -l)" != "$(wc -l < "$work/$name-1.sha256")" ]; then
+        echo "FAILED   $name: the runs gave different files:"
+        cat "$work/$name.diff"
+        failed=1
+    elif [ "$(wc -l < "$work/$name-1.sha256")" -lt 3 ]; then
+        echo "FAILED   $name: the runs wrote almost nothing"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+check "per country"
+check "all outputs" -D --offsets-index --canonicalize
+check "shards" --split-by shard --shards 4
+check "id prefix, one card per line" --split-by id-prefix --one-card-per-line --line-ending crlf
+check "gzip" --compress gzip --flush-every-mb 1
+check "bz2" --compress bz2
+check "xz" --compress xz
+exit $failed
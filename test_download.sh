@@ -0,0 +1,165 @@
+#!/usr/bin/env bash
+# Download tests against a local HTTP server that announces a longer Content-Length than it sends, as a
+# server closing the connection early does: the run must fail and not process or cache the partial export.
+# A partial download left behind by a killed run must be resumed or removed, never used as the export, and a
+# cached export that is cut off must be downloaded again. A resumed download must give exactly the export: also
+# when the partial file was already complete (416), when the server ignores the Range request (200) and when the
+# export changed in between (another ETag), with or without a server that honors If-Range.
+# ./test_download.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'kill $server 2> /dev/null; rm -rf "$work"' EXIT
+
+# /short and /short-etag send half of the export (the latter with an ETag, so it can be resumed), /full all of it.
+# /ranged does what $work/mode says: short (half of it, resumable), range (answers Range requests, 416 past the
+# end), no-range (ignores Range, sends all of it), changed (a new version with another ETag, If-Range honored)
+# and changed-206 (the new version's bytes for a Range request, If-Range ignored).
+python3 - "$root/testdata/multi-entity.xml" "$work/mode" > "$work/port" <<'EOF' &
+import http.server, re, sys
+body = open(sys.argv[1], "rb").read()
+changed = body.replace(b"</root>", b"<!-- the next export -->\n</root>")
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    def ranged(self):
+        mode = open(sys.argv[2]).read().strip()
+        data, etag = (changed, '"v2"') if mode.startswith("changed") else (body, '"v1"')
+        match = re.fullmatch(r"bytes=(\d+)-", self.headers.get("Range") or "")
+        if_range = self.headers.get("If-Range")
+        if match and (mode in ("range", "changed-206") or (mode == "changed" and if_range == etag)):
+            start = int(match.group(1))
+            if start >= len(data):
+                self.send_response(416)
+                self.send_header("Content-Range", f"bytes */{len(data)}")
+                self.send_header("Content-Length", "0")
+                self.end_headers()
+                return
+            self.send_response(206)
+            self.send_header("Content-Range", f"bytes {start}-{len(data) - 1}/{len(data)}")
+            data = data[start:]
+        else:
+            self.send_response(200)
+        self.send_header("Content-Length", str(len(data)))
+        self.send_header("ETag", etag)
+        self.send_header("Accept-Ranges", "bytes")
+        self.end_headers()
+        self.wfile.write(data[:len(data) // 2] if mode == "short" else data)
+        self.close_connection = True
+
+    def do_GET(self):
+        if self.path == "/ranged":
+            return self.ranged()
+        self.send_response(200)
+        self.send_header("Content-Length", str(len(body)))
+        if self.path == "/short-etag":
+            self.send_header("ETag", '"v1"')
+        self.end_headers()
+        self.wfile.write(body if self.path == "/full" else body[:len(body) // 2])
+        self.close_connection = True
+
+    def log_message(self, *args):
+        pass
+
+server = http.server.HTTPServer(("127.0.0.1", 0), Handler)
+print(server.server_address[1], flush=True)
+server.serve_forever()
+EOF
+server=$!
+for _ in $(seq 50); do [ -s "$work/port" ] && break; sleep 0.1; done
+url="http://127.0.0.1:$(cat "$work/port")"
+size=$(wc -c < "$root/testdata/multi-entity.xml")
+half=$(python3 -c "print(f'{$size // 2:,} of {$size:,} bytes')")
+
+failed=0
+check() {  # name, expected exit code, expected stderr text, command...
+    local name=$1 code=$2 text=$3
+    shift 3
+    rm -rf "$work/run" && mkdir -p "$work/run/tmp" "$work/run/docs"
+    (cd "$work/run" && "$@" > /dev/null 2> stderr.txt)
+    local actual=$?
+    if [ $actual -ne "$code" ] || { [ -n "$text" ] && ! grep -qF -- "$text" "$work/run/stderr.txt"; }; then
+        echo "FAILED   $name: exit code $actual, stderr:"
+        cat "$work/run/stderr.txt"
+        failed=1
+        return 1
+    fi
+    echo "ok       $name"
+}
+no_export() {  # name: nothing of the export may be left to process
+    if ls "$work/run/tmp" | grep -q '^directory-export.*\.xml\(\.gz\)\?$'; then
+        echo "FAILED   $1: the truncated export was kept in tmp/: $(ls "$work/run/tmp")"
+        failed=1
+    fi
+}
+
+sync="python3 $root/peppol_sync.py"
+check "truncated download" 1 "Truncated download: got $half" \
+    $sync download -K --url "$url/short" && no_export "truncated download"
+[ -z "$(ls "$work/run/tmp")" ] || { echo "FAILED   truncated download: not resumable, yet kept: $(ls "$work/run/tmp")"; failed=1; }
+check "truncated download, resumable" 1 "the next run resumes it" $sync download -K --url "$url/short-etag" \
+    && no_export "truncated download, resumable"
+ls "$work/run/tmp" | grep -q '\.part$' || { echo "FAILED   truncated download, resumable: no .part kept"; failed=1; }
+check "truncated download, compressed cache" 1 "Truncated download" \
+    $sync download -K --cache-compressed --url "$url/short" && no_export "truncated download, compressed cache"
+check "truncated stream" 1 "The run is partial" $sync sync --stream --no-progress --url "$url/short"
+check "complete download" 0 "" $sync download -K --url "$url/full"
+# a partial download of a killed run that can't be resumed (no validators) is removed, not left behind
+export=$(ls "$work/run/tmp" | grep '\.xml$')
+head -c 100 "$root/testdata/multi-entity.xml" > "$work/run/tmp/$export.part"
+(cd "$work/run" && $sync download -K --url "$url/full" > /dev/null 2>&1)
+if [ -e "$work/run/tmp/$export.part" ] || ! cmp -s "$work/run/tmp/$export" "$root/testdata/multi-entity.xml"; then
+    echo "FAILED   stale partial download: $(ls "$work/run/tmp")"
+    failed=1
+else
+    echo "ok       stale partial download"
+fi
+# a cached export that was cut off (no validators, so no length to compare) is downloaded again, not processed
+head -c "$((size / 2))" "$root/testdata/multi-entity.xml" > "$work/run/tmp/$export"
+(cd "$work/run" && $sync download -K --url "$url/full" > output.txt 2>&1)
+if ! grep -qF "is incomplete" "$work/run/output.txt" || ! cmp -s "$work/run/tmp/$export" "$root/testdata/multi-entity.xml"; then
+    echo "FAILED   truncated cached export:"
+    cat "$work/run/output.txt"
+    failed=1
+else
+    echo "ok       truncated cached export"
+fi
+
+# resuming a partial download: tmp/ keeps the .part of a cut-off download from /ranged, then each case changes it or
+# the server and downloads again; the export must be exactly the server's current one
+rm -rf "$work/run" && mkdir -p "$work/run/tmp" "$work/run/docs"
+python3 - "$root/testdata/multi-entity.xml" "$work/changed.xml" <<'EOF'
+import sys
+body = open(sys.argv[1], "rb").read()
+open(sys.argv[2], "wb").write(body.replace(b"</root>", b"<!-- the next export -->\n</root>"))
+EOF
+resume() {  # name, mode, part (half, full or none), expected export, expected output text
+    local name=$1 mode=$2 part=$3 expected=$4 text=$5
+    rm -f "$work/run/tmp/"*
+    echo short > "$work/mode"
+    (cd "$work/run" && $sync download -K --url "$url/ranged" > /dev/null 2>&1)
+    local part_file
+    part_file=$(ls "$work/run/tmp/"*.part 2> /dev/null)
+    if [ -z "$part_file" ]; then
+        echo "FAILED   $name: no .part to resume: $(ls "$work/run/tmp")"
+        failed=1
+        return
+    fi
+    [ "$part" = full ] && cp "$root/testdata/multi-entity.xml" "$part_file"
+    echo "$mode" > "$work/mode"
+    (cd "$work/run" && $sync download -K --url "$url/ranged" > output.txt 2>&1)
+    local status=$?
+    if [ $status -ne 0 ] || ! cmp -s "${part_file%.part}" "$expected" || [ -e "$part_file" ] \
+            || ! grep -qF -- "$text" "$work/run/output.txt" "$work/run/log/peppol_sync.log"; then
+        echo "FAILED   $name: exit code $status, files $(ls "$work/run/tmp")"
+        cat "$work/run/output.txt"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+resume "resumed download" range half "$root/testdata/multi-entity.xml" "Resuming the download"
+resume "416 for a complete partial download" range full "$root/testdata/multi-entity.xml" "was already complete"
+resume "server ignores Range" no-range half "$root/testdata/multi-entity.xml" "the server sent the whole export"
+resume "ETag changed, If-Range honored" changed half "$work/changed.xml" "the server sent the whole export"
+resume "ETag changed, 206 anyway" changed-206 half "$work/changed.xml" "no longer matches the export"
+exit $failed
@@ -0,0 +1,217 @@
+#!/usr/bin/env bash
+# Encoding tests: an export with cards that are not valid UTF-8 (an overlong sequence, a stray continuation byte,
+# a Latin-1 byte) between valid ones, run with each --invalid-utf8 policy. Whatever the policy, the output files
+# must be valid UTF-8 and the valid cards must come through byte for byte; reject must dead-letter the bad cards
+# unchanged, replace must keep them with U+FFFD for the bad bytes, keep must leave them to the parser,
+# which dead-letters the cards it can't parse.
+# Exports in ISO-8859-1 (declared in the prolog) and UTF-16LE (with a byte-order mark) are transcoded: their
+# extracts must be byte for byte those of the same export in UTF-8, and the offset index must point into the
+# original bytes. A byte-order mark or whitespace before the XML declaration is tolerated, a gzip file or a zip
+# archive is read decompressed; anything else that is not XML must stop the run with a message that says what it is.
+# ./test_encoding.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+python3 - "$work/export.xml" <<'EOF'
+import sys
+names = [
+    b"M\xc3\xbcller \xe2\x82\xac \xf0\x9f\x98\x80",  # valid: 2, 3 and 4 byte sequences
+    b"Over\xc0\xaflong",                           # overlong encoding of "/"
+    b"Stray\x80byte",                              # continuation byte without a lead byte
+    b"Caf\xe9 Latin-1",                            # Latin-1, a lead byte followed by ASCII
+    b"Plain",
+]
+cards = []
+for i, name in enumerate(names, 1):
+    cards.append(b'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:%04d"/>'
+                 b'<entity countrycode="BE"><name name="%s"/></entity></businesscard>' % (i, name))
+with open(sys.argv[1], "wb") as f:
+    f.write(b'<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + b"\n".join(cards) + b"\n</root>\n")
+EOF
+
+failed=0
+check() {  # name, policy, checks...: python expressions over the output of a run with --invalid-utf8 policy
+    local name=$1 policy=$2
+    shift 2
+    local dir="$work/$policy"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    if ! (cd "$dir" && python3 "$root/peppol_sync.py" sync -K --invalid-utf8 "$policy" > /dev/null 2> "$dir/stderr.txt"); then
+        echo "FAILED   $name: the run failed:"
+        cat "$dir/stderr.txt"
+        failed=1
+        return
+    fi
+    if ! python3 - "$dir" "$@" <<'EOF'
+import pathlib, re, sys
+dir = pathlib.Path(sys.argv[1])
+outputs = {p: p.read_bytes() for p in sorted((dir / "extracts").glob("[A-Z]*/*.xml"))}
+output = b"".join(outputs.values())
+deadletter = dir / "extracts/_deadletter/cards.xml"
+deadletter = deadletter.read_bytes() if deadletter.exists() else b""
+report = (dir / "docs/report.md").read_text(encoding="utf-8")
+log = (dir / "log/peppol_sync.log").read_text(encoding="utf-8")
+
+def valid_utf8(data):
+    try:
+        data.decode("utf-8")
+        return True
+    except UnicodeDecodeError:
+        return False
+
+def participants(data):
+    return re.findall(rb'value="0208:(\d+)"', data)
+
+def name(participant):
+    match = re.search(rb'value="0208:%s"/>\s*<entity countrycode="BE">\s*<name name="([^"]*)"' % participant, output)
+    return match.group(1).decode("utf-8") if match else None
+
+problems = [check for check in sys.argv[2:] if not eval(check)]
+problems += [f"{p} is not valid UTF-8" for p, data in outputs.items() if not valid_utf8(data)]
+if name(b"0001") != "Müller € 😀":
+    problems.append(f"the valid card 0001 was changed: {name(b'0001')!r}")
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+check "reject" reject \
+    'participants(output) == [b"0001", b"0005"]' \
+    'participants(deadletter) == [b"0002", b"0003", b"0004"]' \
+    'b"Over\xc0\xaflong" in deadletter and b"Stray\x80byte" in deadletter and b"Caf\xe9 Latin-1" in deadletter' \
+    'deadletter.count(b"reason=invalid-utf8") == 3' \
+    '"| utf8_reject | 3 |" in report and "| deadletter_invalid-utf8 | 3 |" in report' \
+    'all(f"0208:000{i} at offset" in log for i in (2, 3, 4))'
+check "replace" replace \
+    'participants(output) == [b"0001", b"0002", b"0003", b"0004", b"0005"]' \
+    'name(b"0002") == "Over��long" and name(b"0003") == "Stray�byte"' \
+    'name(b"0004") == "Caf� Latin-1"' \
+    'not deadletter and "| utf8_replace | 3 |" in report'
+check "keep" keep \
+    'participants(output) == [b"0001", b"0005"]' \
+    'participants(deadletter) == [b"0002", b"0003", b"0004"] and deadletter.count(b"reason=unparseable-xml") == 3' \
+    '"| utf8_keep | 3 |" in report' \
+    'all(f"0208:000{i} at offset" in log for i in (2, 3, 4))'
+
+# the same cards in other encodings: the extracts of each are those of the UTF-8 export
+python3 - "$work" <<'EOF'
+import sys
+names = ["Müller", "Ærø Søndergård", "Ça & Là"]
+for encoding, declared, bom in [("utf-8", "UTF-8", b""), ("iso-8859-1", "ISO-8859-1", b""),
+                                ("utf-16-le", "UTF-16", b"\xff\xfe")]:
+    cards = [f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+             f'<entity countrycode="DK"><name name="{name.replace("&", "&amp;")}"/></entity></businesscard>'
+             for i, name in enumerate(names, 1)]
+    text = (f'<?xml version="1.0" encoding="{declared}"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+    with open(f"{sys.argv[1]}/export-{encoding}.xml", "wb") as f:
+        f.write(bom + text.encode(encoding))
+EOF
+for encoding in utf-8 iso-8859-1 utf-16-le; do
+    dir="$work/$encoding"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export-$encoding.xml" "$dir/tmp/directory-export-business-cards.xml"
+    if ! (cd "$dir" && python3 "$root/peppol_sync.py" sync -K --offsets-index > /dev/null 2> "$dir/stderr.txt" \
+          && python3 "$root/peppol_sync.py" extract --participant 0208:0002 --from tmp/directory-export-business-cards.xml \
+             > "$dir/extract.xml" 2> "$dir/stderr.txt"); then
+        echo "FAILED   $encoding: the run failed:"
+        cat "$dir/stderr.txt"
+        failed=1
+    elif [ "$encoding" != utf-8 ] && ! diff -r "$work/utf-8/extracts/DK" "$dir/extracts/DK" > /dev/null; then
+        echo "FAILED   $encoding: other extracts than from the UTF-8 export"
+        diff -r "$work/utf-8/extracts/DK" "$dir/extracts/DK" | head -20
+        failed=1
+    elif ! grep -q 'encoding="UTF-8"' "$dir"/extracts/DK/*.xml || ! grep -q 'name="Ærø Søndergård"' "$dir"/extracts/DK/*.xml; then
+        echo "FAILED   $encoding: the extracts are not UTF-8 with the names of the export"
+        failed=1
+    elif ! grep -q '^<businesscard><participant [^>]*0208:0002"/>.*name="Ærø Søndergård".*</businesscard>$' "$dir/extract.xml"; then
+        echo "FAILED   $encoding: extract by offset did not give the card of 0208:0002: $(cat "$dir/extract.xml")"
+        failed=1
+    else
+        echo "ok       $encoding"
+    fi
+done
+
+# the UTF-8 export behind each byte-order mark, after leading whitespace and compressed
+python3 - "$work" <<'EOF'
+import codecs, gzip, io, sys, zipfile
+text = open(f"{sys.argv[1]}/export-utf-8.xml", encoding="utf-8").read()
+variants = {
+    "bom-utf-8": codecs.BOM_UTF8 + text.encode("utf-8"),
+    "bom-utf-16-le": codecs.BOM_UTF16_LE + text.replace('encoding="UTF-8"', 'encoding="UTF-16"').encode("utf-16-le"),
+    "bom-utf-16-be": codecs.BOM_UTF16_BE + text.replace('encoding="UTF-8"', 'encoding="UTF-16"').encode("utf-16-be"),
+    "leading-whitespace": b"\r\n  \t\n" + text.encode("utf-8"),
+    "bom-and-whitespace": codecs.BOM_UTF8 + b"\n\n" + text.encode("utf-8"),
+    "gzip": gzip.compress(text.encode("utf-8")),
+    "not-xml": b'{"error": "rate limited"}\n',
+}
+archive = io.BytesIO()
+with zipfile.ZipFile(archive, "w") as z:
+    z.writestr("directory-export-business-cards.xml", text)
+variants["zip"] = archive.getvalue()
+for name, data in variants.items():
+    with open(f"{sys.argv[1]}/export-{name}.xml", "wb") as f:
+        f.write(data)
+EOF
+for variant in bom-utf-8 bom-utf-16-le bom-utf-16-be leading-whitespace bom-and-whitespace gzip zip; do
+    dir="$work/$variant"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export-$variant.xml" "$dir/tmp/directory-export-business-cards.xml"
+    if ! (cd "$dir" && python3 "$root/peppol_sync.py" sync -K > /dev/null 2> "$dir/stderr.txt"); then
+        echo "FAILED   $variant: the run failed:"
+        cat "$dir/stderr.txt"
+        failed=1
+    elif ! diff -r "$work/utf-8/extracts/DK" "$dir/extracts/DK" > /dev/null; then
+        echo "FAILED   $variant: other extracts than from the UTF-8 export"
+        diff -r "$work/utf-8/extracts/DK" "$dir/extracts/DK" | head -20
+        failed=1
+    else
+        echo "ok       $variant"
+    fi
+done
+# the offset index of a gzip-compressed --input points into the decompressed XML, and extract reads it from there
+dir="$work/gzip-index"
+mkdir -p "$dir/tmp" "$dir/docs"
+cp "$work/export-gzip.xml" "$dir/export.xml.gz"
+if ! (cd "$dir" && python3 "$root/peppol_sync.py" sync --input export.xml.gz --offsets-index > /dev/null 2> "$dir/stderr.txt" \
+      && python3 "$root/peppol_sync.py" extract --participant 0208:0002 --from export.xml.gz \
+         > "$dir/extract.xml" 2> "$dir/stderr.txt"); then
+    echo "FAILED   gzip offsets: the run failed:"
+    cat "$dir/stderr.txt"
+    failed=1
+elif ! grep -q '^<businesscard><participant [^>]*0208:0002"/>.*name="Ærø Søndergård".*</businesscard>$' "$dir/extract.xml"; then
+    echo "FAILED   gzip offsets: extract by offset did not give the card of 0208:0002: $(cat "$dir/extract.xml")"
+    failed=1
+else
+    echo "ok       gzip offsets"
+fi
+for variant in not-xml:"does not look like XML"; do
+    message=${variant#*:}
+    variant=${variant%%:*}
+    dir="$work/$variant"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export-$variant.xml" "$dir/tmp/directory-export-business-cards.xml"
+    if (cd "$dir" && python3 "$root/peppol_sync.py" sync -K > "$dir/stdout.txt" 2>&1); then
+        echo "FAILED   $variant: the run succeeded"
+        failed=1
+    elif ! grep -qF "directory-export-business-cards.xml $message" "$dir/stdout.txt"; then
+        echo "FAILED   $variant: not the expected message: $(cat "$dir/stdout.txt")"
+        failed=1
+    elif [ -n "$(find "$dir/extracts" -name '*.xml' 2> /dev/null)" ]; then
+        echo "FAILED   $variant: extracts written for an input that is not XML"
+        failed=1
+    else
+        echo "ok       $variant rejected"
+    fi
+done
+exit $failed
@@ -0,0 +1,105 @@
+#!/usr/bin/env bash
+# Enrichment pipeline tests: the no-op enricher goes through the whole pipeline without changing a single card, and
+# its calls show up in run.json and the summary; an enricher that raises on some cards (registered by a wrapper with
+# @register_enricher) is counted as failed for those cards without failing the run or stopping the next enricher.
+# ./test_enrich.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i in range(1, 13):
+    country = ["BE", "NL", "DE"][i % 3]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i} {"x" * 200}"/></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+# runs the tool with an extra enricher "failing", which raises on every third card
+cat > "$work/inject.py" <<'EOF'
+import os, sys
+sys.argv = sys.argv[1:]
+sys.path.insert(0, os.path.dirname(sys.argv[0]))
+import peppol_sync
+
+@peppol_sync.register_enricher
+class FailingEnricher(peppol_sync.Enricher):
+    name = "failing"
+    seen = 0
+
+    def enrich(self, element, bucket):
+        self.seen += 1
+        if self.seen % 3 == 0:
+            raise RuntimeError(f"injected at card {self.seen}")
+
+sys.exit(peppol_sync.main())
+EOF
+
+failed=0
+run() {  # name, options...: a sync in its own directory through the wrapper
+    local dir="$work/$1"
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$work/inject.py" "$root/peppol_sync.py" sync -K -M 1000 "$@" > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+}
+check() {  # name, checks...: python expressions over the outcome of run name
+    local name=$1
+    shift
+    if ! python3 - "$work" "$name" "$@" <<'EOF'
+import filecmp, json, pathlib, sys
+work = pathlib.Path(sys.argv[1])
+dir = work / sys.argv[2]
+status = int((dir / "status").read_text())
+output = (dir / "stdout.txt").read_text(encoding="utf-8") + (dir / "stderr.txt").read_text(encoding="utf-8")
+log = (dir / "log/peppol_sync.log").read_text(encoding="utf-8") if (dir / "log/peppol_sync.log").exists() else ""
+run = json.loads((dir / "extracts/run.json").read_text()) if (dir / "extracts/run.json").exists() else {}
+enrichers = run.get("enrichers", {})
+
+def same_cards():
+    """the country files are those of the run without enrichers"""
+    names = sorted(p.relative_to(work / "reference").as_posix() for p in (work / "reference").glob("extracts/*/*.xml"))
+    return len(names) > 3 and names == sorted(p.relative_to(dir).as_posix() for p in dir.glob("extracts/*/*.xml")) \
+        and all(filecmp.cmp(work / "reference" / n, dir / n, shallow=False) for n in names)
+
+problems = [check for check in sys.argv[3:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print(f"exit code {status}\n{output}")
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+run reference
+check reference 'status == 0' 'enrichers == {}' '"Enrichment" not in output'
+
+run noop --enrich noop
+check noop 'status == 0' 'same_cards()' 'list(enrichers) == ["noop"]' \
+    'enrichers["noop"]["calls"] == 12 and enrichers["noop"]["cache_hits"] == 0 and enrichers["noop"]["failures"] == 0' \
+    '"Enrichment" in output and "12 calls, 0 cached, 0 failed" in output' \
+    'not (dir / "cache/noop").exists()'
+
+run "failing, then noop" --enrich failing,noop
+check "failing, then noop" 'status == 0' 'same_cards()' 'list(enrichers) == ["failing", "noop"]' \
+    'enrichers["failing"]["calls"] == 12 and enrichers["failing"]["failures"] == 4' \
+    'enrichers["noop"]["calls"] == 12 and enrichers["noop"]["failures"] == 0' \
+    'log.count("enrich failing: RuntimeError: injected at card") == 4'
+
+run "repeated flag" --enrich noop --enrich noop
+check "repeated flag" 'status == 0' 'list(enrichers) == ["noop"] and enrichers["noop"]["calls"] == 12'
+
+run "unknown enricher" --enrich noop,nope
+check "unknown enricher" 'status == 2' '"unknown enricher nope" in output' 'not (dir / "extracts").exists()'
+exit $failed
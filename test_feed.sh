@@ -0,0 +1,121 @@
+#!/usr/bin/env bash
+# Atom feed tests: runs with --diff over an export that changes between the runs. The first run has no baseline
+# and writes no feed; every later run adds an entry, and the feed must stay valid Atom (RFC 4287): the required
+# elements of the feed and of each entry, RFC 3339 dates, unique entry ids that don't change from one run to the
+# next, links to delta files that exist, newest entry first and no more than --feed-entries entries.
+# ./test_feed.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+mkdir -p "$work/tmp" "$work/docs"
+
+export_with() {  # participant:country:name ...: write the export of the next run with these cards
+    python3 - "$work/tmp/directory-export-business-cards.xml" "$@" <<'EOF'
+import sys
+cards = []
+for card in sys.argv[2:]:
+    participant, country, name = card.split(":")
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{participant}"/>'
+                 f'<entity countrycode="{country}"><name name="{name}"/><regdate>2020-01-01</regdate></entity>'
+                 f'</businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+}
+
+failed=0
+run() {  # name, options...: a --diff run, one second after the previous one so it gets another run id
+    local name=$1
+    shift
+    sleep 1
+    if ! (cd "$work" && python3 "$root/peppol_sync.py" sync -K -D "$@" > /dev/null 2> "$work/stderr.txt"); then
+        echo "FAILED   $name: the run failed:"
+        cat "$work/stderr.txt"
+        failed=1
+        return 1
+    fi
+}
+check_feed() {  # name, expected number of entries
+    local name=$1
+    if ! python3 - "$work/extracts" "$2" "$work/previous-ids" <<'EOF'
+import pathlib, re, sys, xml.etree.ElementTree as ET
+extracts, expected, previous_ids = pathlib.Path(sys.argv[1]), int(sys.argv[2]), pathlib.Path(sys.argv[3])
+ATOM = "{http://www.w3.org/2005/Atom}"
+RFC3339 = re.compile(r"\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?(Z|[+-]\d\d:\d\d)$")
+feed = ET.parse(extracts / "changes.atom").getroot()
+problems = []
+
+def one(element, name, where):
+    found = element.findall(ATOM + name)
+    if len(found) != 1:
+        problems.append(f"{where}: {len(found)} <{name}> elements instead of one")
+        return None
+    return found[0]
+
+def date(element, where):
+    if element is not None and not RFC3339.match(element.text or ""):
+        problems.append(f"{where}: <updated> {element.text!r} is not an RFC 3339 date")
+    return element.text if element is not None else ""
+
+if feed.tag != ATOM + "feed":
+    sys.exit(f"the root element is {feed.tag}, not an Atom feed")
+one(feed, "id", "feed")
+one(feed, "title", "feed")
+date(one(feed, "updated", "feed"), "feed")
+entries = feed.findall(ATOM + "entry")
+if not feed.findall(ATOM + "author") and any(not e.findall(ATOM + "author") for e in entries):
+    problems.append("feed: no <author>, on the feed or on every entry")
+if len(entries) != expected:
+    problems.append(f"{len(entries)} entries instead of {expected}")
+ids, dates = [], []
+for number, entry in enumerate(entries, 1):
+    where = f"entry {number}"
+    entry_id = one(entry, "id", where)
+    ids.append(entry_id.text if entry_id is not None else "")
+    one(entry, "title", where)
+    dates.append(date(one(entry, "updated", where), where))
+    links = entry.findall(ATOM + "link")
+    if entry.find(ATOM + "content") is None and not any(l.get("rel", "alternate") == "alternate" for l in links):
+        problems.append(f"{where}: no <content> and no alternate link")
+    for link in links:
+        if not (extracts / link.get("href", "")).is_file():
+            problems.append(f"{where}: the link {link.get('href')!r} is not a file in extracts/")
+if len(set(ids)) != len(ids):
+    problems.append(f"entry ids are not unique: {ids}")
+if dates != sorted(dates, reverse=True):
+    problems.append(f"the newest entry is not first: {dates}")
+# the entries kept from the previous run have the ids they had then
+if previous_ids.exists():
+    kept = previous_ids.read_text().split()[:len(ids) - 1]
+    if ids[1:] != kept:
+        problems.append(f"the ids of the previous entries changed: {ids[1:]} instead of {kept}")
+previous_ids.write_text("\n".join(ids))
+sys.exit("\n".join(problems) or None)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+export_with 0001:BE:Alpha 0002:NL:Beta 0003:DE:Gamma
+if run "first run"; then
+    if [ -e "$work/extracts/changes.atom" ] || ls "$work/extracts/_diff" | grep -q '^delta-'; then
+        echo "FAILED   first run: a delta or feed without a baseline"
+        failed=1
+    else
+        echo "ok       first run"
+    fi
+fi
+# one card added, one removed and one changed
+export_with 0001:BE:Alpha 0002:NL:Beta2 0004:FR:Delta
+run "second run" && check_feed "second run" 1
+export_with 0001:BE:Alpha 0002:NL:Beta2 0004:FR:Delta 0005:BE:Epsilon
+run "third run" --feed-entries 2 && check_feed "third run" 2
+export_with 0001:BE:Alpha 0004:FR:Delta 0005:BE:Epsilon
+run "fourth run, oldest entry dropped" --feed-entries 2 && check_feed "fourth run, oldest entry dropped" 2
+exit $failed
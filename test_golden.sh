@@ -0,0 +1,63 @@
+#!/usr/bin/env bash
+# Golden-output tests: runs a full sync on every testdata/*.xml export in a temporary directory and compares
+# the exit code, extracts/ and the report with testdata/golden/<name>/. The card files must also be well-formed
+# XML with every namespace prefix bound. The other options are checked against the same fixtures by their own
+# scripts (test_workers.sh, test_sort.sh, test_csv.sh, test_sqlite.sh, test_country_index.sh).
+# ./test_golden.sh [--update] [name ...]   --update regenerates the expectations; review them with git diff
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+update=0
+if [ "${1:-}" = "--update" ]; then
+    update=1
+    shift
+fi
+names=("$@")
+if [ ${#names[@]} -eq 0 ]; then
+    for fixture in "$root"/testdata/*.xml; do
+        names+=("$(basename "$fixture" .xml)")
+    done
+fi
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+failed=0
+for name in "${names[@]}"; do
+    work="$tmp/$name"
+    mkdir -p "$work/tmp" "$work/docs" "$work/actual"
+    cp "$root/testdata/$name.xml" "$work/tmp/directory-export-business-cards.xml"
+    # small files, so the fixtures also cover the file rotation
+    (cd "$work" && python3 "$root/peppol_sync.py" sync -K -S --deterministic --no-progress -M 20000 \
+        > /dev/null 2> "$work/stderr.txt")
+    echo "$?" > "$work/actual/exit-code"
+    [ -d "$work/extracts" ] && cp -r "$work/extracts" "$work/actual/extracts"
+    [ -f "$work/docs/report.md" ] && cp "$work/docs/report.md" "$work/actual/report.md"
+    # strict consumers need well-formed files with every namespace prefix bound
+    if ! python3 - "$work/extracts" <<'EOF'
+import pathlib, sys, xml.etree.ElementTree as ET
+for path in sorted(pathlib.Path(sys.argv[1]).glob("*/business-cards.*.xml")):
+    try:
+        ET.parse(path)
+    except ET.ParseError as e:
+        sys.exit(f"{path.name} in {path.parent.name}: {e}")
+EOF
+    then
+        echo "FAILED   $name: an output file is not well-formed XML"
+        cat "$work/stderr.txt"
+        failed=1
+    fi
+
+    golden="$root/testdata/golden/$name"
+    if [ $update -eq 1 ]; then
+        rm -rf "$golden"
+        mkdir -p "$(dirname "$golden")"
+        cp -r "$work/actual" "$golden"
+        echo "updated  $name"
+    elif diff -r -u "$golden" "$work/actual"; then
+        echo "ok       $name"
+    else
+        echo "FAILED   $name"
+        cat "$work/stderr.txt"
+        failed=1
+    fi
+done
+exit $failed
@@ -0,0 +1,104 @@
+#!/usr/bin/env bash
+# NumberFormat tests, table driven: each row is a --report-locale, a --size-unit, a call on the NumberFormat they make
+# and the text it must return. The rows cover zero, negatives (nothing that rounds to zero reads as -0), rounding
+# boundaries (Python rounds the binary value half to even, so 2.675 is 2.67 and 0.5 is 0), the separators of every
+# locale (narrow and plain no-break spaces in French and the Nordic locales), very large values (counts exactly,
+# past what a float holds), and sizes whose rounding carries them into the next unit.
+# ./test_numbers.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+
+python3 - "$root" <<'EOF'
+import sys
+sys.path.insert(0, sys.argv[1])
+from peppol_sync import NumberFormat
+
+TABLE = """
+en|MiB|number(0)|0
+en|MiB|number(0, 2)|0.00
+en|MiB|number(0.0)|0
+en|MiB|percent(0)|0.0%
+plain|MiB|number(0, 3)|0.000
+en|MiB|number(-1)|-1
+en|MiB|number(-1234567, 2)|-1,234,567.00
+en|MiB|number(-1234567)|-1,234,567
+de|MiB|number(-1234567.891, 2)|-1.234.567,89
+fr|MiB|number(-1234.5, 1)|-1\u202f234,5
+en|MiB|number(-0.4)|0
+en|MiB|number(-0.5)|0
+en|MiB|number(-0.6)|-1
+en|MiB|number(-0.004, 2)|0.00
+en|MiB|number(-0.005, 2)|-0.01
+de|MiB|number(-0.0001, 3)|0,000
+en|MiB|percent(-0.04)|0.0%
+en|MiB|percent(-0.05)|-0.1%
+en|MiB|number(0.5)|0
+en|MiB|number(1.5)|2
+en|MiB|number(2.5)|2
+en|MiB|number(0.125, 2)|0.12
+en|MiB|number(0.375, 2)|0.38
+en|MiB|number(2.675, 2)|2.67
+en|MiB|number(1.005, 2)|1.00
+en|MiB|number(999.995, 2)|1,000.00
+en|MiB|number(999.4999)|999
+en|MiB|number(999.5)|1,000
+en|MiB|number(999999.5)|1,000,000
+nl|MiB|percent(99.95)|100,0%
+nl|MiB|percent(99.94)|99,9%
+en|MiB|number(1234567.891, 2)|1,234,567.89
+de|MiB|number(1234567.891, 2)|1.234.567,89
+nl|MiB|number(1234567.891, 2)|1.234.567,89
+da|MiB|number(1234567.891, 2)|1.234.567,89
+es|MiB|number(1234567.891, 2)|1.234.567,89
+it|MiB|number(1234567.891, 2)|1.234.567,89
+fr|MiB|number(1234567.891, 2)|1\u202f234\u202f567,89
+pl|MiB|number(1234567.891, 2)|1\xa0234\xa0567,89
+sv|MiB|number(1234567.891, 2)|1\xa0234\xa0567,89
+fi|MiB|number(1234567.891, 2)|1\xa0234\xa0567,89
+no|MiB|number(1234567.891, 2)|1\xa0234\xa0567,89
+de-CH|MiB|number(1234567.891, 2)|1'234'567.89
+plain|MiB|number(1234567.891, 2)|1234567.89
+en|MiB|number(999)|999
+de|MiB|number(1000)|1.000
+de|MiB|number(0.5, 1)|0,5
+en|MiB|number(10 ** 18)|1,000,000,000,000,000,000
+de-CH|MiB|number(2 ** 63 - 1)|9'223'372'036'854'775'807
+en|MiB|number(-10 ** 15)|-1,000,000,000,000,000
+plain|MiB|number(10 ** 18)|1000000000000000000
+en|MiB|number(1.5e20)|150,000,000,000,000,000,000
+en|auto|size(0)|0 B
+en|auto|size(999)|999 B
+en|auto|size(1000)|1.00 kB
+en|auto|size(999994)|999.99 kB
+en|auto|size(999995)|1.00 MB
+en|auto|size(999999)|1.00 MB
+en|auto|size(999999999)|1.00 GB
+en|auto|size(999999999, 0)|1 GB
+en|auto|size(999499, 0)|999 kB
+en|auto|size(10 ** 15)|1,000,000.00 GB
+de|auto|size(10 ** 18)|1.000.000.000,00 GB
+en|MiB|size(0)|0.00 MiB
+en|MiB|size(1024 ** 2)|1.00 MiB
+en|MiB|size(10 ** 15)|953,674,316.41 MiB
+fr|MB|size(10 ** 15)|1\u202f000\u202f000\u202f000,00 MB
+en|GB|size(999999999)|1.00 GB
+en|GB|size(1)|0.00 GB
+en|MiB|size_cell(0)|0.00
+de|MB|size_cell(2 ** 40)|1.099.511,63
+de|auto|size_cell(999999)|1,00 MB
+"""
+
+failed = 0
+for row in TABLE.strip().splitlines():
+    locale, unit, call, expected = row.split("|")
+    try:
+        got = eval(f"numbers.{call}", {"numbers": NumberFormat(locale, unit)})
+    except Exception as e:
+        got = f"{type(e).__name__}: {e}"
+    if got == expected:
+        print(f"ok       {locale} {unit} {call}")
+    else:
+        print(f"FAILED   {locale} {unit} {call}: {got!r}, expected {expected!r}")
+        failed = 1
+sys.exit(failed)
+EOF
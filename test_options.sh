@@ -0,0 +1,102 @@
+#!/usr/bin/env bash
+# Option validation tests, table driven: each row is a command line with the problems it must report (the lines of
+# stderr after "error: ", separated by ||), or "ok" for a combination that must be accepted and run. Rejected command
+# lines exit with 2 before anything is written, and all of their problems are reported at once.
+# ./test_options.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = [f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+         f'<entity countrycode="{["BE", "NL"][i % 2]}"><name name="Company {i}"/></entity></businesscard>'
+         for i in range(1, 5)]
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+failed=0
+case_number=0
+while IFS='|' read -r options expected; do
+    [ -z "$options" ] && continue
+    case_number=$((case_number + 1))
+    dir="$work/$case_number"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    # shellcheck disable=SC2086
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K $options > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+    if ! python3 - "$dir" "$expected" <<'EOF'
+import pathlib, sys
+dir, expected = pathlib.Path(sys.argv[1]), sys.argv[2].strip()
+status = int((dir / "status").read_text())
+err = (dir / "stderr.txt").read_text(encoding="utf-8")
+errors = [line.split("error: ", 1)[1] for line in err.splitlines() if ": error: " in line]
+if expected == "ok":
+    problems = [] if status == 0 and not errors else [f"exit code {status}, errors {errors}"]
+else:
+    wanted = [problem.strip() for problem in expected.split(" || ")]
+    problems = [] if status == 2 and errors == wanted and not (dir / "extracts").exists() else \
+        [f"exit code {status}", *(f"reported: {e}" for e in errors), *(f"expected: {w}" for w in wanted)]
+if problems:
+    print("\n".join(problems))
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $options"
+        failed=1
+    else
+        echo "ok       $options"
+    fi
+done <<'EOF'
+--compress gzip --compress-level 9|ok
+--compress xz --compress-level 0|ok
+--compress-level 5|--compress-level needs a codec: add --compress gzip, bz2 or xz
+--compress gzip --compress-level 10|--compress-level for gzip must be between 1 and 9
+--compress xz --compress-level 10|--compress-level for xz must be between 0 and 9
+--compress gzip --flush-every-mb 1|ok
+--compress xz --flush-every-mb 1|--flush-every-mb is only supported with --compress gzip: add it or drop --flush-every-mb
+--sample 0.5 --seed 1|ok
+--sample 0|--sample must be a probability between 0 and 1, e.g. --sample 0.01 for 1%
+--sample 1.5|--sample must be a probability between 0 and 1, e.g. --sample 0.01 for 1%
+--seed 1|--seed only seeds --sample: add --sample or drop --seed
+--min-entities 2 --max-entities 1|--min-entities must be 0 or more and --max-entities at least --min-entities
+--min-entities -1|--min-entities must be 0 or more and --max-entities at least --min-entities
+--matrix-top 0|--matrix-top must be at least 1
+--max-files-per-country -1|--max-files-per-country must be 0 (no limit) or a positive number of files
+--limit -1|--limit must be 0 (no limit) or a positive number of cards
+--limit 2|ok
+--verify-rate 0|--verify-sample must be 0 or more and --verify-rate above 0
+--enrich noop|ok
+--enrich noop,nope|--enrich: unknown enricher nope, choose from noop, geocode, lei
+--enrich noop --dry-run|--enrich only enriches written cards and --dry-run writes none: drop one of them
+--enrich noop --stats-only|--enrich only enriches written cards and --stats-only writes none: drop one of them
+--read-chunk-kb 0|--read-chunk-kb and --write-buffer-kb must be at least 1
+--auto-tune --write-buffer-kb 16|ok
+--enrich-workers 0|--lei-rate must be above 0, --enrich-workers at least 1 and --lei-max-lookups 0 or more
+--geocode-rate 0|--geocode-rate must be above 0, geocoding is always rate limited
+--timezone Europe/Brussels|ok
+--timezone Mars/Olympus|--timezone: unknown time zone 'Mars/Olympus', use a name like Europe/Brussels or UTC
+-S -V|--silent and --verbose contradict each other: drop one of them
+--dry-run --stats-only|--dry-run and --stats-only: --dry-run writes nothing, --stats-only writes the report and stats.json; drop one of them
+--dry-run --dry-run-report|ok
+--dry-run-report|--dry-run-report only applies to --dry-run: add --dry-run or drop --dry-run-report
+-C --append|ok
+--append|--append keeps the existing output files, but without -C they are deleted first: add -C or drop --append
+--sink ndjson --sink sqlite:format=xml:abort|ok
+--sink ndjson:format=csv|--sink ndjson:format=csv: ndjson writes format record or xml
+--sink kafka|--sink kafka: use NAME[:format=FORMAT][:abort|skip], with NAME one of ndjson, sqlite
+--sink ndjson:abort:skip|--sink ndjson:abort:skip: use NAME[:format=FORMAT][:abort|skip], with NAME one of ndjson, sqlite
+--bundle|ok
+--bundle bundle.tar.zst|--bundle takes a path only for the reproduce action, sync writes runs/<run id>/ itself: drop the path
+--bundle --dry-run|--bundle packs the written extracts and --dry-run writes none: drop one of them
+--bundle-without-export|--bundle-without-export only applies to sync --bundle: add --bundle or drop it
+--seed 1 -S -V --matrix-top 0|--seed only seeds --sample: add --sample or drop --seed || --matrix-top must be at least 1 || --silent and --verbose contradict each other: drop one of them
+--compress xz --checkpoint-every 5|ok
+--resume --compress gzip --flush-every-mb 1|ok
+--checkpoint-every 5 --sink ndjson --dedupe|--checkpoint-every can't carry on --dedupe, --sink from a checkpoint: drop --checkpoint-every or --dedupe and --sink
+EOF
+exit $failed
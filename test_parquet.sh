@@ -0,0 +1,59 @@
+#!/usr/bin/env bash
+# Parquet output tests: a sync with --format parquet of every testdata/*.xml export, with small files so the
+# rollover by rows is covered, reads the files back and checks them against the stats.json of the run: as many
+# participants per bucket as cards_by_bucket, as many rows as entities_by_bucket, no file over --max-rows.
+# Skipped (exit 0) without pyarrow, which --format parquet needs.
+# ./test_parquet.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+if ! python3 -c "import pyarrow.parquet" 2> /dev/null; then
+    echo "skipped  pyarrow is not installed"
+    exit 0
+fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+failed=0
+for fixture in "$root"/testdata/*.xml; do
+    name=$(basename "$fixture" .xml)
+    dir="$work/$name"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$fixture" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -S --deterministic --no-progress --format parquet --max-rows 2 \
+        > /dev/null 2> "$dir/stderr.txt")
+    if ! python3 - "$dir/extracts" 2 <<'EOF'
+import json, pathlib, sys
+import pyarrow.parquet
+extracts, max_rows = pathlib.Path(sys.argv[1]), int(sys.argv[2])
+stats = json.loads((extracts / "stats.json").read_text(encoding="utf-8"))
+problems = []
+for bucket, cards in sorted(stats["cards_by_bucket"].items()):
+    participants, rows, without_entities = set(), 0, 0
+    for path in sorted((extracts / bucket).glob("business-cards.*.parquet")):
+        table = pyarrow.parquet.read_table(path)
+        ids = table.column("participant").to_pylist()
+        # the rows of a card stay together, a file only goes over the limit with those of its last card
+        last_card = len(ids)
+        while last_card and ids[last_card - 1] == ids[-1]:
+            last_card -= 1
+        if last_card >= max_rows:
+            problems.append(f"{path.name} in {bucket}: {table.num_rows} rows, more than --max-rows {max_rows}")
+        participants.update(ids)
+        rows += table.num_rows
+        # a card without entities has one row with empty entity columns
+        without_entities += sum(1 for row in table.select(["country", "name", "regdate"]).to_pylist() if not any(row.values()))
+    if len(participants) != cards:
+        problems.append(f"{bucket}: {len(participants)} participants in the files, {cards} cards in stats.json")
+    entities = stats["entities_by_bucket"].get(bucket, 0)
+    if rows - without_entities != entities:
+        problems.append(f"{bucket}: {rows - without_entities} entity rows in the files, {entities} entities in stats.json")
+sys.exit("\n".join(problems) or None)
+EOF
+    then
+        echo "FAILED   $name (stderr: $dir/stderr.txt)"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+done
+exit $failed
@@ -0,0 +1,143 @@
+#!/usr/bin/env bash
+# Re-run tests: a second sync into the same extracts/. Without -C the old files are replaced by identical ones;
+# with -C alone the run refuses to start and leaves the files untouched; with -C --append every country continues
+# after its highest sequence number (a new country starts at 000001), all files stay well-formed XML, and stats.json
+# and the report count the cards and files of all runs. The files that list cards next to them (XX/reasons.csv,
+# _INVALID/values.csv and the --sink outputs) keep the cards of the earlier runs and get those of the new one added.
+# ./test_rerun.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+mkdir -p "$work/tmp" "$work/docs"
+
+export_with() {  # countries...: an export with three cards per country, long enough for two files each with -M 1000;
+                 # XX gives cards without a country code
+    python3 - "$work/tmp/directory-export-business-cards.xml" "$@" <<'EOF'
+import sys
+cards = []
+for country in sys.argv[2:]:
+    for i in range(1, 4):
+        cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{country}{i:04d}"/>'
+                     f'<entity{"" if country == "XX" else f" countrycode={chr(34)}{country}{chr(34)}"}><name name="Company {i} {"x" * 300}"/></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+}
+
+failed=0
+sync() {  # expected exit code, options...
+    local expected=$1
+    shift
+    (cd "$work" && python3 "$root/peppol_sync.py" sync -K -M 1000 "$@" > "$work/stdout.txt" 2> "$work/stderr.txt")
+    local status=$?
+    if [ $status != "$expected" ]; then
+        echo "FAILED   sync $*: exit code $status instead of $expected"
+        cat "$work/stderr.txt"
+        failed=1
+        return 1
+    fi
+}
+checksums() {
+    (cd "$work/extracts" && find . -name 'business-cards.*' | sort | xargs sha256sum)
+}
+check() {  # name, python expressions over the files in extracts/ and the stderr of the last run
+    local name=$1
+    shift
+    if ! python3 - "$work" "$@" <<'EOF'
+import csv, gzip, json, pathlib, sqlite3, sys, xml.etree.ElementTree as ET
+work = pathlib.Path(sys.argv[1])
+err = (work / "stderr.txt").read_text(encoding="utf-8")
+stats = json.loads((work / "extracts/stats.json").read_text(encoding="utf-8"))
+report = (work / "docs/report.md").read_text(encoding="utf-8")
+
+def files(country):
+    return [p.name for p in sorted((work / "extracts" / country).glob("business-cards.*.xml"))]
+
+def cards(country, name):
+    return [p.get("value") for p in ET.parse(work / "extracts" / country / name).getroot().iter("participant")]
+
+def listed(name):  # the participant values of a CSV file in extracts/, without its header
+    with open(work / "extracts" / name, encoding="utf-8", newline="") as f:
+        return [row[0].split("::")[-1] for row in csv.reader(f)][1:]
+
+def ndjson_cards():
+    with gzip.open(work / "extracts/cards.ndjson.gz", "rt", encoding="utf-8") as f:
+        return len(f.readlines())
+
+def sqlite_cards():
+    db = sqlite3.connect(work / "extracts/cards.sqlite")
+    try:
+        return db.execute("SELECT count(*) FROM cards").fetchone()[0]
+    finally:
+        db.close()
+
+problems = [check for check in sys.argv[2:] if not eval(check)]
+for path in sorted((work / "extracts").glob("*/business-cards.*.xml")):
+    try:
+        ET.parse(path)
+    except ET.ParseError as e:
+        problems.append(f"{path.relative_to(work)} is not well-formed: {e}")
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+export_with BE NL
+sync 0 && check "first run" \
+    'files("BE") == ["business-cards.000001.xml", "business-cards.000002.xml"]' \
+    'cards("BE", "business-cards.000002.xml") == ["0208:BE0003"]'
+checksums > "$work/first.sha256"
+
+sleep 1
+sync 0
+if [ "$(checksums)" != "$(cat "$work/first.sha256")" ]; then
+    echo "FAILED   second run: other files than the first run"
+    failed=1
+else
+    echo "ok       second run"
+fi
+
+if sync 1 -C; then
+    if [ "$(checksums)" != "$(cat "$work/first.sha256")" ]; then
+        echo "FAILED   -C: the existing files were changed"
+        failed=1
+    else
+        check "-C refuses" '"already has output files (BE, NL)" in err' '"--append" in err'
+    fi
+fi
+
+export_with BE NL DE
+sync 0 -C --append && check "-C --append" \
+    'files("BE") == [f"business-cards.00000{i}.xml" for i in range(1, 5)]' \
+    'files("NL") == [f"business-cards.00000{i}.xml" for i in range(1, 5)]' \
+    'cards("BE", "business-cards.000003.xml") == ["0208:BE0001", "0208:BE0002"]' \
+    'cards("BE", "business-cards.000004.xml") == ["0208:BE0003"]' \
+    'files("DE") == ["business-cards.000001.xml", "business-cards.000002.xml"]' \
+    'stats["cards"] == 15 and stats["cards_by_bucket"] == stats["cards_by_country"] == {"BE": 6, "DE": 3, "NL": 6}' \
+    '{name: bucket["files"] for name, bucket in stats["buckets"].items()} == {"BE": 4, "DE": 2, "NL": 4}' \
+    '"| **Total** | **10** | **15** |" in report'
+if ! (cd "$work/extracts" && sha256sum --quiet -c "$work/first.sha256" > /dev/null 2>&1); then
+    echo "FAILED   -C --append: the existing files were changed"
+    failed=1
+fi
+
+sync 0 -C --append && check "-C --append again" \
+    'files("BE")[-1] == "business-cards.000006.xml" and files("DE")[-1] == "business-cards.000004.xml"'
+
+export_with BE XX 1A
+sync 0 -C --append --sink ndjson --sink sqlite && sync 0 -C --append --sink ndjson --sink sqlite \
+    && check "-C --append side files" \
+    'files("XX") == [f"business-cards.00000{i}.xml" for i in range(1, 5)]' \
+    'listed("XX/reasons.csv") == 2 * ["0208:XX0001", "0208:XX0002", "0208:XX0003"]' \
+    'listed("_INVALID/values.csv") == 2 * ["0208:1A0001", "0208:1A0002", "0208:1A0003"]' \
+    'ndjson_cards() == 18' 'sqlite_cards() == 18'
+exit $failed
@@ -0,0 +1,117 @@
+#!/usr/bin/env bash
+# Checkpoint and resume tests: a run that dies on a card (--strict and a malformed card stand in for an OOM kill
+# or a full disk) is continued with --resume, and must give the very same files as a run that never stopped:
+# the reopened files don't get a second header, the cards written after the checkpoint are not there twice and
+# the files started after it are replaced. Compressed files (--compress gzip, bz2, xz) are cut back to the end of the
+# gzip member or stream of the checkpoint and continued in a new one, so they must decompress to the same files. A
+# resume with another export or other options is refused.
+# ./test_resume.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+# 24 cards in three countries, small enough for a few cards per file with -M 1500; card 17 is not well-formed
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i in range(1, 25):
+    name = f'<name name="Company {i} {"x" * 300}"/>' if i != 17 else f'<name name="Company {i}">'
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{["BE", "NL", "DE"][i % 3]}">{name}<regdate>2020-01-01</regdate></entity>'
+                 f'</businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+sync="python3 $root/peppol_sync.py sync -S --deterministic --no-progress -M 1500"
+run() {  # directory, options...: a sync of the export in its own working directory
+    local dir=$1
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    [ -f "$dir/tmp/directory-export-business-cards.xml" ] || cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && $sync "$@" > /dev/null 2>> "$dir/stderr.txt")
+}
+
+failed=0
+run "$work/expected" -K
+# checkpoints before the malformed card, right before it, and with cards and new files written after the last one
+for every in 1 4 5 7 16; do
+    name="checkpoint every $every cards"
+    dir="$work/every-$every"
+    run "$dir" --strict --checkpoint-every "$every"
+    if [ ! -f "$dir/tmp/checkpoint.json" ]; then
+        echo "FAILED   $name: no checkpoint left by the run that died"
+        failed=1
+        continue
+    fi
+    run "$dir" --resume --checkpoint-every "$every"
+    if [ -e "$dir/tmp/checkpoint.json" ]; then
+        echo "FAILED   $name: the checkpoint is still there after the resumed run"
+        failed=1
+    elif ! diff -r -q -x '*.json' "$work/expected/extracts" "$dir/extracts" > /dev/null; then
+        echo "FAILED   $name: the resumed run wrote other files than a run that never stopped:"
+        diff -r -x '*.json' "$work/expected/extracts" "$dir/extracts"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+done
+
+# the same for compressed files: a gzip member or a bz2 or xz stream is ended at the checkpoint, the resumed run
+# writes a new one after it, and the files must be valid and decompress to those of a run that never stopped (small
+# compressed files roll over by --max-cards)
+for codec in gzip bz2 xz; do
+    run "$work/expected-$codec" -K --compress "$codec" --max-cards 3
+    for every in 4 7; do
+        name="checkpoint every $every cards, $codec"
+        dir="$work/$codec-every-$every"
+        run "$dir" --strict --checkpoint-every "$every" --compress "$codec" --max-cards 3
+        if [ ! -f "$dir/tmp/checkpoint.json" ]; then
+            echo "FAILED   $name: no checkpoint left by the run that died"
+            failed=1
+            continue
+        fi
+        run "$dir" --resume --checkpoint-every "$every" --compress "$codec" --max-cards 3
+        if ! python3 - "$work/expected-$codec/extracts" "$dir/extracts" <<'EOF'
+import bz2, gzip, lzma, pathlib, sys
+expected, resumed = (pathlib.Path(arg) for arg in sys.argv[1:])
+open_file = {".gz": gzip.open, ".bz2": bz2.open, ".xz": lzma.open}
+names = sorted(path.relative_to(expected) for path in expected.glob("*/*.xml.*"))
+if len(names) < 6 or names != sorted(path.relative_to(resumed) for path in resumed.glob("*/*.xml.*")):
+    sys.exit(f"other files: {names}")
+for name in names:
+    with open_file[name.suffix](expected / name) as a, open_file[name.suffix](resumed / name) as b:
+        if a.read() != b.read():
+            sys.exit(f"{name} decompresses to other cards")
+EOF
+        then
+            echo "FAILED   $name"
+            failed=1
+        else
+            echo "ok       $name"
+        fi
+    done
+done
+
+check_refused() {  # name, expected stderr text, options of the resumed run
+    local name=$1 text=$2
+    shift 2
+    local dir="$work/refused"
+    rm -rf "$dir"
+    run "$dir" --strict --checkpoint-every 5
+    # a download of a later export replaces the one the checkpoint was taken of
+    [ -n "${change_export:-}" ] && sed -i 's/Company 1 /Company 9 /' "$dir/tmp/directory-export-business-cards.xml"
+    if run "$dir" "$@" || ! grep -qF -- "$text" "$dir/stderr.txt"; then
+        echo "FAILED   $name:"
+        cat "$dir/stderr.txt"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+check_refused "resume with other options" "was taken with other max_bytes" --resume -M 2000
+check_refused "resume with other compression" "was taken with other compress" --resume --compress gzip
+change_export=1 check_refused "resume with another export" "is not the export" --resume
+exit $failed
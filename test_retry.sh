@@ -0,0 +1,91 @@
+#!/usr/bin/env bash
+# retry-deadletter tests: an export with cards without a country, with a country code that is not ISO 3166 and with
+# invalid UTF-8, which a sync dead-letters: rejected with --invalid-utf8 reject, or as unparseable XML (also listed
+# in XX/reasons.csv) with keep. Retried with --invalid-utf8 replace, they go to new files after the existing ones
+# and the dead-letter file is left empty. XX/reasons.csv and _INVALID/values.csv must still list the cards of the
+# sync, without the recovered unparseable ones, the --sink output must have the recovered cards added, and
+# stats.json and the report must count all cards.
+# ./test_retry.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i, (country, name) in enumerate([(b"BE", b"Plain"), (b"BE", b"Caf\xe9 Latin-1"), (None, b"Nowhere"),
+                                     (b"1A", b"Invalid"), (b"NL", b"Stray\x80byte"), (b"NL", b"Plain")], 1):
+    entity = b'<entity countrycode="%s">' % country if country else b"<entity>"
+    cards.append(b'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:%04d"/>%s<name name="%s"/>'
+                 b"</entity></businesscard>" % (i, entity, name))
+with open(sys.argv[1], "wb") as f:
+    f.write(b'<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + b"\n".join(cards) + b"\n</root>\n")
+EOF
+
+failed=0
+run() {  # directory, action and options: the tool in $work/directory, with the export
+    local dir="$work/$1"
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    [ -e "$dir/tmp/directory-export-business-cards.xml" ] || cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" "$@" > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    local status=$?
+    if [ $status != 0 ]; then
+        echo "FAILED   $*: exit code $status"
+        cat "$dir/stderr.txt"
+        failed=1
+        return 1
+    fi
+}
+check() {  # name, directory, python expressions over the files in its extracts/ and its report
+    local name=$1 dir=$2
+    shift 2
+    if ! python3 - "$work/$dir" "$@" <<'EOF'
+import csv, gzip, json, pathlib, sys
+dir = pathlib.Path(sys.argv[1])
+stats = json.loads((dir / "extracts/stats.json").read_text(encoding="utf-8"))
+report = (dir / "docs/report.md").read_text(encoding="utf-8")
+deadletter = dir / "extracts/_deadletter/cards.xml"
+deadletter = deadletter.read_bytes() if deadletter.exists() else b""
+
+def listed(name):  # the participant values of a CSV file in extracts/, without its header
+    with open(dir / "extracts" / name, encoding="utf-8", newline="") as f:
+        return [row[0].split("::")[-1] for row in csv.reader(f)][1:]
+
+def ndjson():
+    with gzip.open(dir / "extracts/cards.ndjson.gz", "rt", encoding="utf-8") as f:
+        return sorted(json.loads(line)["participant"]["value"] for line in f)
+
+def files(country):
+    return [p.name for p in sorted((dir / "extracts" / country).glob("business-cards.*.xml"))]
+
+problems = [check for check in sys.argv[2:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+for policy in reject keep; do
+    run $policy sync -K --invalid-utf8 $policy --sink ndjson && check "sync, $policy" $policy \
+        'deadletter.count(b"<businesscard>") == 2' 'listed("_INVALID/values.csv") == ["0208:0004"]' \
+        'listed("XX/reasons.csv") == (["0208:0003"] if dir.name == "reject" else ["0208:0002", "0208:0003", "0208:0005"])' \
+        'ndjson() == ["0208:0001", "0208:0003", "0208:0004", "0208:0006"]'
+
+    run $policy retry-deadletter --invalid-utf8 replace --sink ndjson && check "retry-deadletter after $policy" $policy \
+        'not deadletter' 'files("BE") == ["business-cards.000001.xml", "business-cards.000002.xml"]' \
+        'files("NL") == ["business-cards.000001.xml", "business-cards.000002.xml"]' \
+        'listed("XX/reasons.csv") == ["0208:0003"]' 'listed("_INVALID/values.csv") == ["0208:0004"]' \
+        'ndjson() == [f"0208:{i:04d}" for i in range(1, 7)]' \
+        'stats["cards_by_bucket"] == {"BE": 2, "NL": 2, "XX": 1, "_INVALID": 1}' \
+        '"| **Total** | **6** | **6** |" in report' '"| no-countrycode | 1 | 100.0% |" in report'
+done
+exit $failed
@@ -0,0 +1,90 @@
+#!/usr/bin/env bash
+# roundtrip-check tests: cards using every element and attribute of the business card schema (names with and without
+# a language, address, identifiers, websites, contacts, additional information, registration date, document types)
+# come back from their record unchanged, in the export and in the extracts of a sync, and the ndjson sink writes all
+# of it. A card with anything the record has no field for (another element, another attribute) must be reported as a
+# difference, with the lost lines in its diff, and fail the check.
+# ./test_roundtrip.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+python3 - "$work" <<'EOF'
+import pathlib, sys
+work = pathlib.Path(sys.argv[1])
+cards = []
+for i in range(1, 7):
+    country = ["BE", "NL", "DE"][i % 3]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i} &amp; Co" language="nl"/>'
+                 f'<name name="Société {i}"/><geoinfo>Street {i}\nTown</geoinfo>'
+                 f'<id scheme="VAT" value="{country}{i:09d}"/><id scheme="LEI" value="5493{i:016d}"/>'
+                 f'<website>https://company{i}.example</website><website>https://shop{i}.example</website>'
+                 f'<contact type="sales" name="Desk {i}" phonenumber="+32 {i}" email="sales@company{i}.example"/>'
+                 f'<contact type="support" email="help@company{i}.example"/>'
+                 f'<additionalinfo>Open 9-17</additionalinfo><regdate>2020-01-0{i}</regdate></entity>'
+                 f'<entity countrycode="FR"><name name="Branch {i}"/></entity>'
+                 f'<doctypeid scheme="busdox-docid-qns" value="urn:invoice:{i}"/></businesscard>')
+for name, extra in [("export.xml", []),
+                    ("unknown.xml", ['<businesscard><participant scheme="iso6523-actorid-upis" value="0208:0101"/>'
+                                     '<entity countrycode="BE" vatstatus="active"><name name="Extra"/>'
+                                     '<logo href="https://extra.example/logo.png"/></entity></businesscard>'])]:
+    (work / name).write_text('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+                             + "\n".join(cards + extra) + "\n</root>\n", encoding="utf-8")
+EOF
+
+failed=0
+run() {  # name, export, action and options: the tool in its own directory
+    local dir="$work/$1" export=$2
+    shift 2
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/$export" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" "$@" > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+}
+check() {  # name, checks...: python expressions over the outcome of run name
+    local name=$1
+    shift
+    if ! python3 - "$work" "$name" "$@" <<'EOF'
+import gzip, json, pathlib, sys
+work = pathlib.Path(sys.argv[1])
+dir = work / sys.argv[2]
+status = int((dir / "status").read_text())
+output = (dir / "stdout.txt").read_text(encoding="utf-8") + (dir / "stderr.txt").read_text(encoding="utf-8")
+
+def ndjson():
+    with gzip.open(dir / "extracts/cards.ndjson.gz", "rt", encoding="utf-8") as f:
+        return [json.loads(line) for line in f]
+
+problems = [check for check in sys.argv[3:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print(f"exit code {status}\n{output}")
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+run export export.xml roundtrip-check --from tmp/directory-export-business-cards.xml
+check export 'status == 0' '"0 differences across 6 cards" in output'
+
+run extracts export.xml sync -K -M 1000 --sink ndjson
+(cd "$work/extracts" && python3 "$root/peppol_sync.py" roundtrip-check > stdout.txt 2> stderr.txt; echo $? > status)
+check extracts 'status == 0' '"0 differences across 12 cards" in output' 'len(ndjson()) == 6' \
+    'all(len(line["entities"][0]["contacts"]) == 2 and line["entities"][0]["contacts"][1]["name"] is None
+         for line in ndjson())' \
+    'all(line["entities"][0]["names"][0]["language"] == "nl" and len(line["entities"][0]["identifiers"]) == 2
+         for line in ndjson())' \
+    'all(line["entities"][0]["websites"][1].startswith("https://shop") for line in ndjson())' \
+    'all(line["entities"][0]["additionalinfo"] == "Open 9-17" for line in ndjson())'
+
+run unknown unknown.xml roundtrip-check --from tmp/directory-export-business-cards.xml
+check unknown 'status == 1' '"1 differences across 7 cards" in output' '"0208:0101" in output' \
+    '"-<logo" in output' '"vatstatus" in output'
+exit $failed
@@ -0,0 +1,152 @@
+#!/usr/bin/env bash
+# Sink tests: every card goes to each --sink from the one parse, in the format the sink declares (format=record or
+# format=xml, whatever --format the card files have), and the card files are those of a run without sinks. Each sink
+# has its own error policy: sinks that fail (registered by a wrapper with @register_sink, one failing on its fifth
+# card, one when it is opened) with skip leave the other sinks and the card files complete and end the run as a
+# partial success (exit code 2); one that fails with abort stops the run (exit code 1), whatever the other sinks do.
+# ./test_sinks.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i in range(1, 13):
+    country = ["BE", "NL", "DE"][i % 3]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i} {"x" * 200}"/></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+# runs the tool with two extra sinks: "flaky" fails on the fifth card, "broken" can't be opened
+cat > "$work/inject.py" <<'EOF'
+import os, sys
+sys.argv = sys.argv[1:]
+sys.path.insert(0, os.path.dirname(sys.argv[0]))
+import peppol_sync
+
+@peppol_sync.register_sink
+class FlakySink(peppol_sync.Sink):
+    name = "flaky"
+
+    def write(self, element, bucket):
+        if self.cards == 4:
+            raise OSError("injected: connection reset")
+
+@peppol_sync.register_sink
+class BrokenSink(peppol_sync.Sink):
+    name = "broken"
+
+    def __init__(self, sync, policy="skip", format=None):
+        super().__init__(sync, policy, format)
+        raise OSError("injected: no route to host")
+
+    def write(self, element, bucket):
+        pass
+
+sys.exit(peppol_sync.main())
+EOF
+
+failed=0
+run() {  # name, options...: a sync in its own directory through the wrapper
+    local dir="$work/$1"
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$work/inject.py" "$root/peppol_sync.py" sync -K -M 1000 "$@" > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+}
+check() {  # name, checks...: python expressions over the outcome of run name
+    local name=$1
+    shift
+    if ! python3 - "$work" "$name" "$@" <<'EOF'
+import filecmp, gzip, json, pathlib, sqlite3, sys
+import xml.etree.ElementTree as ET
+work = pathlib.Path(sys.argv[1])
+dir = work / sys.argv[2]
+status = int((dir / "status").read_text())
+output = (dir / "stdout.txt").read_text(encoding="utf-8") + (dir / "stderr.txt").read_text(encoding="utf-8")
+run = json.loads((dir / "extracts/run.json").read_text()) if (dir / "extracts/run.json").exists() else {}
+sinks, failed_sinks = run.get("sinks", {}), run.get("failed_sinks", {})
+
+def same_cards():
+    """the country files are those of the run without sinks"""
+    names = sorted(p.relative_to(work / "reference").as_posix() for p in (work / "reference").glob("extracts/*/*.xml"))
+    return len(names) > 3 and names == sorted(p.relative_to(dir).as_posix() for p in dir.glob("extracts/*/*.xml")) \
+        and all(filecmp.cmp(work / "reference" / n, dir / n, shallow=False) for n in names)
+
+def ndjson():
+    with gzip.open(dir / "extracts/cards.ndjson.gz", "rt", encoding="utf-8") as f:
+        return [json.loads(line) for line in f]
+
+def sqlite():
+    db = sqlite3.connect(dir / "extracts/cards.sqlite")
+    try:
+        return db.execute("SELECT participant, bucket, country, card FROM cards ORDER BY participant").fetchall()
+    finally:
+        db.close()
+
+participants = [f"iso6523-actorid-upis::0208:{i:04d}" for i in range(1, 13)]
+problems = [check for check in sys.argv[3:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print(f"exit code {status}\n{output}")
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+run reference
+check reference 'status == 0' 'sinks == {}'
+
+run "record and xml" --sink ndjson --sink sqlite:format=xml
+check "record and xml" 'status == 0' 'same_cards()' \
+    'sinks["ndjson"] == {"policy": "skip", "format": "record", "cards": 12, "error": None}' \
+    'sinks["sqlite"] == {"policy": "skip", "format": "xml", "cards": 12, "error": None}' \
+    'sorted(line["participant"]["value"] for line in ndjson()) == [f"0208:{i:04d}" for i in range(1, 13)]' \
+    'all(line["bucket"] == line["entities"][0]["countrycode"] for line in ndjson())' \
+    '[row[0] for row in sqlite()] == participants' \
+    'all(ET.fromstring(row[3]).find("entity").get("countrycode") == row[2] == row[1] for row in sqlite())'
+
+run "xml and record" --sink ndjson:format=xml:abort --sink sqlite:format=record
+check "xml and record" 'status == 0' 'same_cards()' \
+    'sinks["ndjson"]["format"] == "xml" and sinks["ndjson"]["policy"] == "abort"' \
+    'sorted("iso6523-actorid-upis::" + ET.fromstring(line["xml"]).find("participant").get("value")
+            for line in ndjson()) == participants' \
+    'all(set(line) == {"bucket", "xml"} for line in ndjson())' \
+    'all(json.loads(row[3])["entities"][0]["countrycode"] == row[2] for row in sqlite())'
+
+run "failing sink skipped" --sink flaky --sink ndjson
+check "failing sink skipped" 'status == 2' 'same_cards()' 'len(ndjson()) == 12' \
+    'sinks["flaky"]["cards"] == 4 and "connection reset" in sinks["flaky"]["error"]' \
+    'sinks["ndjson"]["cards"] == 12 and sinks["ndjson"]["error"] is None' \
+    'list(failed_sinks) == ["flaky"]' '"Partial success: 1 buckets or sinks failed: sink:flaky" in output'
+
+run "unopenable sink skipped" --sink broken --sink sqlite:format=xml
+check "unopenable sink skipped" 'status == 2' 'same_cards()' 'len(sqlite()) == 12' \
+    '"no route to host" in failed_sinks["broken"]' '"Partial success: 1 buckets or sinks failed: sink:broken" in output'
+
+run "two sinks skipped" --sink broken --sink flaky --sink ndjson
+check "two sinks skipped" 'status == 2' 'same_cards()' 'len(ndjson()) == 12' \
+    'sorted(failed_sinks) == ["broken", "flaky"]'
+
+run "failing sink aborts" --sink flaky:abort --sink ndjson
+check "failing sink aborts" 'status == 1' '"connection reset" in output'
+
+# the worst outcome wins: the abort of one sink over the skip of another
+run "abort over skip" --sink broken:skip --sink flaky:abort
+check "abort over skip" 'status == 1' '"connection reset" in output'
+
+run "skipped sink, healthy abort sink" --sink flaky:skip --sink ndjson:abort
+check "skipped sink, healthy abort sink" 'status == 2' 'same_cards()' 'len(ndjson()) == 12' \
+    'list(failed_sinks) == ["flaky"]'
+exit $failed
@@ -0,0 +1,48 @@
+#!/usr/bin/env bash
+# --sort tests: syncs every testdata/*.xml export with --sort, as it is and with its cards in reverse order. Both
+# must give the same card files, whatever order the cards come in.
+# ./test_sort.sh [name ...]
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+names=("$@")
+if [ ${#names[@]} -eq 0 ]; then
+    for fixture in "$root"/testdata/*.xml; do
+        names+=("$(basename "$fixture" .xml)")
+    done
+fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+failed=0
+sync() {  # directory, export: a sync with --sort of the export in $work/$name/directory
+    local dir="$work/$name/$1"
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$2" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -S --deterministic --no-progress -M 20000 --sort \
+        > /dev/null 2> "$dir/stderr.txt")
+}
+
+for name in "${names[@]}"; do
+    mkdir -p "$work/$name"
+    python3 - "$root/testdata/$name.xml" "$work/$name/reversed.xml" <<'EOF'
+import re, sys
+text = open(sys.argv[1], encoding="utf-8").read()
+starts = [m.start() for m in re.finditer(r"<businesscard[\s>]", text)]
+if starts:
+    end = text.rindex("</root>")
+    cards = [text[a:b].rstrip() for a, b in zip(starts, starts[1:] + [end])]
+    text = text[:starts[0]] + "\n".join(reversed(cards)) + "\n" + text[end:]
+open(sys.argv[2], "w", encoding="utf-8").write(text)
+EOF
+    sync as-is "$root/testdata/$name.xml"
+    sync reversed "$work/$name/reversed.xml"
+    if ! diff -r -q -x '*.json' -x '*.csv' -x _diff -x _deadletter \
+            "$work/$name/as-is/extracts" "$work/$name/reversed/extracts"; then
+        echo "FAILED   $name: --sort wrote other files for the cards in reverse order"
+        cat "$work/$name/reversed/stderr.txt"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+done
+exit $failed
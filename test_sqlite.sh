@@ -0,0 +1,63 @@
+#!/usr/bin/env bash
+# --format sqlite tests: syncs every testdata/*.xml export into peppol.db, with the exit code of the golden run, then
+# syncs it again into the same extracts/ with a malformed card added at the end and --strict. That run fails after
+# all other cards went into the database being built, and must leave the peppol.db of the run before it as it was,
+# byte for byte, with no peppol.db.tmp next to it.
+# ./test_sqlite.sh [name ...]
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+names=("$@")
+if [ ${#names[@]} -eq 0 ]; then
+    for fixture in "$root"/testdata/*.xml; do
+        names+=("$(basename "$fixture" .xml)")
+    done
+fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+failed=0
+sync() {  # export, options...: a --format sqlite sync of the export in $work/$name
+    local dir="$work/$name" export=$1
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$export" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -S --deterministic --no-progress --format sqlite "$@" \
+        > /dev/null 2>> "$dir/stderr.txt")
+}
+
+for name in "${names[@]}"; do
+    dir="$work/$name"
+    sync "$root/testdata/$name.xml"
+    echo "$?" > "$dir/exit-code"
+    cp "$dir/extracts/peppol.db" "$dir/previous.db" 2> /dev/null
+    python3 - "$root/testdata/$name.xml" "$dir/broken.xml" <<'EOF'
+import sys
+text = open(sys.argv[1], encoding="utf-8").read()
+end = text.rindex("</")
+open(sys.argv[2], "w", encoding="utf-8").write(
+    text[:end] + '<businesscard><participant scheme="iso6523-actorid-upis" value="0208:9999999999"/>'
+    '<entity countrycode="BE"><name name="Broken"></entity></businesscard>\n' + text[end:])
+EOF
+    sync "$dir/broken.xml" --strict
+    status=$?
+    if ! cmp -s "$dir/exit-code" "$root/testdata/golden/$name/exit-code"; then
+        echo "FAILED   $name: exit code $(cat "$dir/exit-code"), the golden run has $(cat "$root/testdata/golden/$name/exit-code")"
+        cat "$dir/stderr.txt"
+        failed=1
+    elif [ ! -f "$dir/previous.db" ] || [ $status -ne 1 ] || ! cmp -s "$dir/previous.db" "$dir/extracts/peppol.db" \
+            || [ -e "$dir/extracts/peppol.db.tmp" ] || ! python3 - "$dir/extracts/peppol.db" <<'EOF'
+import sqlite3, sys
+db = sqlite3.connect(sys.argv[1])
+if db.execute("PRAGMA integrity_check").fetchone() != ("ok",) \
+        or db.execute("SELECT count(*) FROM participants WHERE value = '0208:9999999999'").fetchone() != (0,):
+    sys.exit("peppol.db is damaged or has the cards of the failed run")
+EOF
+    then
+        echo "FAILED   $name: the failed --strict run (exit code $status) didn't keep the previous peppol.db"
+        cat "$dir/stderr.txt"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+done
+exit $failed
@@ -0,0 +1,122 @@
+#!/usr/bin/env bash
+# StatsD tests: a run with --statsd-addr sends its metrics to a fake agent, a UDP listener that records the
+# packets. Checks the metric names, types and tags: cards per country (folded into country:other beyond
+# --statsd-max-countries), rollovers per output bucket, parse errors, the run duration tagged with the outcome and the
+# --statsd-tags on every metric; and that a run without an agent listening is not held up.
+# ./test_statsd.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'kill $listener 2> /dev/null; rm -rf "$work"' EXIT
+
+# 12 cards: 6 BE, 4 NL, 2 DE, with names long enough for a few cards per file with -M 1000; one is not well-formed
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i, country in enumerate(["BE"] * 6 + ["NL"] * 4 + ["DE"] * 2, 1):
+    name = f'<name name="Company {i} {"x" * 200}"/>' if i != 5 else '<name name="Broken">'
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}">{name}<regdate>2020-01-01</regdate></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+# the fake agent: one metric per line in packets.txt
+python3 - "$work/packets.txt" > "$work/port" <<'EOF' &
+import socket, sys
+sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+sock.bind(("127.0.0.1", 0))
+print(sock.getsockname()[1], flush=True)
+with open(sys.argv[1], "a", encoding="utf-8") as f:  # appending, so the test can empty the file
+    while True:
+        f.write(sock.recv(65535).decode("utf-8") + "\n")
+        f.flush()
+EOF
+listener=$!
+for _ in $(seq 50); do [ -s "$work/port" ] && break; sleep 0.1; done
+port=$(cat "$work/port")
+
+run() {  # directory, options...: a sync of the export in its own working directory
+    local dir=$1
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    [ -e "$dir/tmp/directory-export-business-cards.xml" ] || cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -M 1000 "$@" > /dev/null 2> "$dir/stderr.txt")
+}
+wait_for() {  # text: wait until the agent received it, the packets are sent when the run ends
+    for _ in $(seq 50); do grep -qF -- "$1" "$work/packets.txt" && return 0; sleep 0.1; done
+    return 1
+}
+
+failed=0
+check_metrics() {  # name, checks...: python expressions over the metrics received (name, value, type, tags)
+    local name=$1
+    shift
+    if ! python3 - "$work/packets.txt" "$@" <<'EOF'
+import sys
+metrics = []
+for line in open(sys.argv[1], encoding="utf-8").read().split():
+    fields = line.split("|")
+    name, value = fields[0].split(":")
+    tags = fields[2][1:].split(",") if len(fields) > 2 else []
+    metrics.append((name, int(value), fields[1], tags))
+
+def total(name, tag=None):
+    return sum(v for n, v, _, t in metrics if n == name and (tag is None or tag in t))
+
+problems = [check for check in sys.argv[2:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print("metrics received:\n" + "\n".join(map(str, metrics)))
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+run "$work/success" --statsd-addr "127.0.0.1:$port" --statsd-tags env:test,team:data --statsd-max-countries 1
+if ! wait_for "peppol.run.duration"; then
+    echo "FAILED   successful run: no run.duration metric received (stderr: $work/success/stderr.txt)"
+    failed=1
+else
+    check_metrics "successful run" \
+        'all(n.startswith("peppol.") and {"env:test", "team:data"} <= set(t) for n, _, _, t in metrics)' \
+        'total("peppol.cards.processed", "country:BE") == 5' \
+        'total("peppol.cards.processed", "country:other") == 6' \
+        'total("peppol.cards.processed") == 11' \
+        'all(k == "c" for n, _, k, _ in metrics if n == "peppol.cards.processed")' \
+        'total("peppol.rollover", "bucket:BE") >= 1 and total("peppol.rollover", "bucket:NL") >= 1' \
+        'total("peppol.parse.errors") == 1' \
+        '[(k, t[-1]) for n, _, k, t in metrics if n == "peppol.run.duration"] == [("ms", "status:success")]'
+fi
+
+# an export that can't be read: the run fails and says so in the status tag
+: > "$work/packets.txt"
+mkdir -p "$work/failure/tmp/directory-export-business-cards.xml"
+run "$work/failure" --statsd-addr "127.0.0.1:$port"
+if ! wait_for "peppol.run.duration"; then
+    echo "FAILED   failed run: no run.duration metric received (stderr: $work/failure/stderr.txt)"
+    failed=1
+else
+    check_metrics "failed run" \
+        '[t for n, _, _, t in metrics if n == "peppol.run.duration"] == [["status:failure"]]'
+fi
+
+# nobody listening on the port: the metrics are lost, the run goes on as without them
+kill $listener
+start=$(date +%s)
+if ! run "$work/no-agent" --statsd-addr "127.0.0.1:$port" || [ $(($(date +%s) - start)) -gt 10 ]; then
+    echo "FAILED   no agent: the run failed or took too long (stderr: $work/no-agent/stderr.txt)"
+    failed=1
+elif ! diff -r -q -x run.json -x stats.json "$work/success/extracts" "$work/no-agent/extracts" > /dev/null; then
+    echo "FAILED   no agent: other files than with an agent"
+    failed=1
+else
+    echo "ok       no agent"
+fi
+exit $failed
@@ -0,0 +1,40 @@
+#!/usr/bin/env bash
+# --workers tests: syncs every testdata/*.xml export with --workers 1 and with --workers 4. The cards written on
+# several threads must give the same exit code and the very same extracts/ as on one, stats.json and run.json
+# included.
+# ./test_workers.sh [name ...]
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+names=("$@")
+if [ ${#names[@]} -eq 0 ]; then
+    for fixture in "$root"/testdata/*.xml; do
+        names+=("$(basename "$fixture" .xml)")
+    done
+fi
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+failed=0
+sync() {  # directory, options...: a sync of the fixture $name in $work/$name/directory
+    local dir="$work/$name/$1"
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$root/testdata/$name.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$root/peppol_sync.py" sync -K -S --deterministic --no-progress -M 20000 "$@" \
+        > /dev/null 2> "$dir/stderr.txt")
+    echo "$?" > "$dir/exit-code"
+}
+
+for name in "${names[@]}"; do
+    sync one --workers 1
+    sync four --workers 4
+    if ! cmp -s "$work/$name/one/exit-code" "$work/$name/four/exit-code" \
+            || ! diff -r -q "$work/$name/one/extracts" "$work/$name/four/extracts"; then
+        echo "FAILED   $name: --workers 4 wrote other files than --workers 1"
+        cat "$work/$name/four/stderr.txt"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+done
+exit $failed
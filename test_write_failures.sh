@@ -0,0 +1,122 @@
+#!/usr/bin/env bash
+# Write failure tests: runs with write errors injected into one bucket, a full disk after the bucket's first files
+# (a wrapper makes OutputFile.write raise ENOSPC) or a bucket directory that can't be created. With
+# --country-error-policy skip the failed bucket's partial files are removed, the other buckets are written exactly
+# as in a run without errors, run.json, the report and --result-line name the failed buckets and the exit code is
+# 2; with the default policy abort the run stops with exit code 1.
+# ./test_write_failures.sh
+set -u
+root=$(cd "$(dirname "$0")" && pwd)
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+# 18 cards, 6 per country, with names long enough for a few files per country with -M 1000
+python3 - "$work/export.xml" <<'EOF'
+import sys
+cards = []
+for i in range(1, 19):
+    country = ["BE", "NL", "DE"][i % 3]
+    cards.append(f'<businesscard><participant scheme="iso6523-actorid-upis" value="0208:{i:04d}"/>'
+                 f'<entity countrycode="{country}"><name name="Company {i} {"x" * 200}"/></entity></businesscard>')
+with open(sys.argv[1], "w", encoding="utf-8") as f:
+    f.write('<?xml version="1.0" encoding="UTF-8"?>\n<root version="2" creationdt="2026-01-01T00:00:00Z">\n'
+            + "\n".join(cards) + "\n</root>\n")
+EOF
+
+# runs the tool with OutputFile.write failing with ENOSPC once FAIL_AFTER characters went to the FAIL_BUCKET files
+cat > "$work/inject.py" <<'EOF'
+import errno, os, sys
+sys.argv = sys.argv[1:]
+sys.path.insert(0, os.path.dirname(sys.argv[0]))
+import peppol_sync
+fail_bucket, fail_after = os.environ.get("FAIL_BUCKET"), int(os.environ.get("FAIL_AFTER", "0"))
+written = {}
+write = peppol_sync.OutputFile.write
+def failing_write(self, text):
+    bucket = self.path.parent.name
+    written[bucket] = written.get(bucket, 0) + len(text)
+    if bucket == fail_bucket and written[bucket] > fail_after:
+        raise OSError(errno.ENOSPC, os.strerror(errno.ENOSPC), str(self.path))
+    return write(self, text)
+peppol_sync.OutputFile.write = failing_write
+sys.exit(peppol_sync.main())
+EOF
+
+failed=0
+run() {  # name, options...: a sync in its own directory through the injection wrapper
+    local dir="$work/$1"
+    shift
+    mkdir -p "$dir/tmp" "$dir/docs"
+    cp "$work/export.xml" "$dir/tmp/directory-export-business-cards.xml"
+    (cd "$dir" && python3 "$work/inject.py" "$root/peppol_sync.py" sync -K -M 1000 "$@" > "$dir/stdout.txt" 2> "$dir/stderr.txt")
+    echo $? > "$dir/status"
+}
+check() {  # name, checks...: python expressions over the outcome of run name
+    local name=$1
+    shift
+    if ! python3 - "$work" "$name" "$@" <<'EOF'
+import filecmp, json, pathlib, sys
+work = pathlib.Path(sys.argv[1])
+dir = work / sys.argv[2]
+status = int((dir / "status").read_text())
+out = (dir / "stdout.txt").read_text(encoding="utf-8")
+err = (dir / "stderr.txt").read_text(encoding="utf-8")
+run = json.loads((dir / "extracts/run.json").read_text()) if (dir / "extracts/run.json").exists() else {}
+report = (dir / "docs/report.md").read_text(encoding="utf-8") if (dir / "docs/report.md").exists() else ""
+
+def files(bucket):
+    return sorted(p.name for p in (dir / "extracts" / bucket).glob("*.xml")) if (dir / "extracts" / bucket).is_dir() else []
+
+def as_reference(bucket):
+    """the bucket's files are those of the run without errors"""
+    reference = work / "reference/extracts" / bucket
+    names = sorted(p.name for p in reference.glob("*.xml"))
+    return len(names) > 1 and files(bucket) == names and \
+        all(filecmp.cmp(reference / n, dir / "extracts" / bucket / n, shallow=False) for n in names)
+
+problems = [check for check in sys.argv[3:] if not eval(check)]
+if problems:
+    print("\n".join(f"not true: {p}" for p in problems))
+    print(f"exit code {status}\nstdout: {out}\nstderr: {err}")
+sys.exit(1 if problems else 0)
+EOF
+    then
+        echo "FAILED   $name"
+        failed=1
+    else
+        echo "ok       $name"
+    fi
+}
+
+run reference
+check reference 'status == 0' 'all(len(files(c)) > 1 for c in ("BE", "NL", "DE"))'
+
+# the disk fills up while NL already has a complete file
+FAIL_BUCKET=NL FAIL_AFTER=1500 run "skip, disk full" --country-error-policy skip --result-line
+check "skip, disk full" \
+    'status == 2' 'files("NL") == []' 'as_reference("BE") and as_reference("DE")' \
+    'run["status"] == "partial" and list(run["failed_buckets"]) == ["NL"]' \
+    '"No space left on device" in run["failed_buckets"]["NL"]' \
+    '"Writing NL failed" in err and "NL" in report and "No space left on device" in report' \
+    'out.startswith("status=partial ") and out.rstrip("\n").endswith(" failed=NL") and out.count("\n") == 1'
+
+# DE can't be created: a file is in the way of its directory
+mkdir -p "$work/skip, no directory/extracts"
+echo "in the way" > "$work/skip, no directory/extracts/DE"
+run "skip, no directory" --country-error-policy skip -C
+check "skip, no directory" \
+    'status == 2' 'as_reference("BE") and as_reference("NL")' \
+    'list(run["failed_buckets"]) == ["DE"] and "Writing DE failed" in err'
+
+# both at once
+mkdir -p "$work/skip, two buckets/extracts"
+echo "in the way" > "$work/skip, two buckets/extracts/DE"
+FAIL_BUCKET=NL FAIL_AFTER=1500 run "skip, two buckets" --country-error-policy skip -C --result-line
+check "skip, two buckets" \
+    'status == 2' 'files("NL") == []' 'as_reference("BE")' \
+    'list(run["failed_buckets"]) == ["DE", "NL"]' 'out.rstrip("\n").endswith(" failed=DE,NL")'
+
+# the default policy stops at the first error
+FAIL_BUCKET=NL FAIL_AFTER=1500 run "abort"
+check "abort" 'status == 1' '"No space left on device" in err' 'run.get("status") != "success"'
+exit $failed